@@ -37,6 +37,11 @@ import (
 // allowed.
 const loginSessionID = "login"
 
+// passwordlessUser is used as the "user" key when storing and retrieving
+// challenge session data for passwordless logins, which aren't associated
+// with any particular user until the assertion response comes back.
+const passwordlessUser = ""
+
 // LoginIdentity represents the subset of Identity methods used by LoginFlow.
 // It exists to better scope LoginFlow's use of Identity and to facilitate
 // testing.
@@ -48,6 +53,19 @@ type LoginIdentity interface {
 	UpsertWebauthnSessionData(ctx context.Context, user, sessionID string, sd *wantypes.SessionData) error
 	GetWebauthnSessionData(ctx context.Context, user, sessionID string) (*wantypes.SessionData, error)
 	DeleteWebauthnSessionData(ctx context.Context, user, sessionID string) error
+
+	// GetTeleportUserByWebauthnID returns the Teleport username associated
+	// with a WebAuthn user handle (ie, WebauthnLocalAuth.UserID). It backs
+	// the reverse index used to resolve the user during passwordless
+	// logins, where the server only learns the webID from the assertion
+	// response's userHandle.
+	GetTeleportUserByWebauthnID(ctx context.Context, webID []byte) (string, error)
+	// UpsertTeleportUserByWebauthnID writes (or refreshes) the webID->user
+	// entry GetTeleportUserByWebauthnID reads. It must be called whenever a
+	// user's WebAuthn ID is established, at registration as well as at
+	// first login, otherwise passwordless login can never resolve a user
+	// from the userHandle alone.
+	UpsertTeleportUserByWebauthnID(ctx context.Context, webID []byte, user string) error
 }
 
 // WithDevices returns a LoginIdentity backed by a fixed set of devices.
@@ -72,21 +90,29 @@ func (l *loginWithDevices) GetMFADevices(ctx context.Context, user string, withS
 //
 // The login flow consists of:
 //
-// 1. Client requests a CredentialAssertion (containing, among other info, a
-//    challenge to be signed)
-// 2. Server runs Begin(), generates a credential assertion.
-// 3. Client validates the assertion, performs a user presence test (usually by
-//    asking the user to touch a secure token), and replies with
-//    CredentialAssertionResponse (containing the signed challenge)
-// 4. Server runs Finish()
-// 5. If all server-side checks are successful, then login/authentication is
-//    complete.
+//  1. Client requests a CredentialAssertion (containing, among other info, a
+//     challenge to be signed)
+//  2. Server runs Begin(), generates a credential assertion.
+//  3. Client validates the assertion, performs a user presence test (usually by
+//     asking the user to touch a secure token), and replies with
+//     CredentialAssertionResponse (containing the signed challenge)
+//  4. Server runs Finish()
+//  5. If all server-side checks are successful, then login/authentication is
+//     complete.
 type LoginFlow struct {
 	U2F      *types.U2F
 	Webauthn *types.Webauthn
 	// Identity is typically an implementation of the Identity service, ie, an
 	// object with access to user, device and MFA storage.
 	Identity LoginIdentity
+	// AuthenticatorPolicy, if set, re-validates the authenticator that
+	// signed the login challenge against FIDO MDS3 on every Finish and
+	// FinishPasswordless call, rejecting devices that have since been
+	// revoked or have fallen below the configured certification level.
+	// Registration-time enforcement (AuthenticatorPolicy.CheckRegistration)
+	// belongs in the registration flow, which this package does not define.
+	// Left nil, no MDS3 check is performed.
+	AuthenticatorPolicy *AuthenticatorPolicy
 }
 
 // Begin is the first step of the LoginFlow.
@@ -122,6 +148,13 @@ func (f *LoginFlow) Begin(ctx context.Context, user string) (*CredentialAssertio
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+	// Keep the webID->user reverse index current. This is what lets a
+	// later passwordless login resolve user from the assertion response's
+	// userHandle alone; the call is idempotent, so repeating it on every
+	// classic login is harmless.
+	if err := f.Identity.UpsertTeleportUserByWebauthnID(ctx, webID, user); err != nil {
+		return nil, trace.Wrap(err)
+	}
 	u := newWebUser(user, webID, true /* credentialIDOnly */, devices)
 
 	// Create the WebAuthn object and create a new challenge.
@@ -228,6 +261,11 @@ func (f *LoginFlow) Finish(ctx context.Context, user string, resp *CredentialAss
 		log.Warnf(
 			"WebAuthn: Clone warning detected for user %q / device %q. Device counter may be malfunctioning.", user, dev.GetName())
 	}
+	if f.AuthenticatorPolicy != nil {
+		if err := f.AuthenticatorPolicy.CheckLogin(aaguidString(credential.Authenticator.AAGUID)); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
 
 	// Update last used timestamp and device counter.
 	if err := setCounterAndTimestamps(dev, credential); err != nil {
@@ -246,6 +284,155 @@ func (f *LoginFlow) Finish(ctx context.Context, user string, resp *CredentialAss
 	return dev, nil
 }
 
+// BeginPasswordless is the first step of a passwordless login, aka
+// usernameless or "tap-to-login". Unlike Begin, it doesn't require a
+// username upfront: the generated CredentialAssertion has an empty
+// allowCredentials list and requires user verification, so any resident
+// (discoverable) credential on the authenticator may be used to complete
+// the ceremony.
+//
+// As a side effect, BeginPasswordless stores the login SessionData under a
+// challenge-derived key, since there's no user to scope it to yet.
+func (f *LoginFlow) BeginPasswordless(ctx context.Context) (*CredentialAssertion, error) {
+	if f.Webauthn.Disabled {
+		return nil, trace.BadParameter("webauthn disabled")
+	}
+
+	// No devices to set as allowCredentials: any resident credential
+	// present on the authenticator is accepted.
+	u := newWebUser("" /* user */, nil /* webID */, true /* credentialIDOnly */, nil /* devices */)
+
+	web, err := newWebAuthn(f.Webauthn, f.Webauthn.RPID, "" /* origin */)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	assertion, sessionData, err := web.BeginLogin(u, wan.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	sessionDataPB, err := sessionToPB(sessionData)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := f.Identity.UpsertWebauthnSessionData(
+		ctx, passwordlessUser, challengeSessionID(sessionDataPB), sessionDataPB); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return (*CredentialAssertion)(assertion), nil
+}
+
+// FinishPasswordless is the second and last step of BeginPasswordless. It
+// recovers the user from the assertion response's userHandle (the
+// WebAuthn user ID assigned at registration time), verifies the signed
+// challenge, and returns the user and device used to solve it.
+func (f *LoginFlow) FinishPasswordless(ctx context.Context, resp *CredentialAssertionResponse) (user string, dev *types.MFADevice, err error) {
+	switch {
+	case f.Webauthn.Disabled:
+		return "", nil, trace.BadParameter("webauthn disabled")
+	case resp == nil:
+		return "", nil, trace.BadParameter("credential assertion response required")
+	}
+
+	parsedResp, err := parseCredentialResponse(resp)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+
+	origin := parsedResp.Response.CollectedClientData.Origin
+	if err := validateOrigin(origin, f.Webauthn.RPID); err != nil {
+		log.WithError(err).Debugf("WebAuthn: origin validation failed")
+		return "", nil, trace.Wrap(err)
+	}
+
+	webID := parsedResp.Response.UserHandle
+	if len(webID) == 0 {
+		return "", nil, trace.BadParameter("passwordless login requires a resident credential, but no userHandle was returned")
+	}
+
+	user, foundDev, err := resolveUserByWebauthnID(ctx, f.Identity, webID, parsedResp.RawID)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	if foundDev.GetU2F() != nil {
+		return "", nil, trace.BadParameter("U2F devices don't support resident credentials and can't be used for passwordless login")
+	}
+	dev = foundDev
+
+	wla, err := f.Identity.GetWebauthnLocalAuth(ctx, user)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	u := newWebUser(user, wla.UserID, false /* credentialIDOnly */, []*types.MFADevice{dev})
+
+	sessionID := parsedResp.Response.CollectedClientData.Challenge
+	sessionDataPB, err := f.Identity.GetWebauthnSessionData(ctx, passwordlessUser, sessionID)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	sessionData := sessionFromPB(sessionDataPB)
+
+	web, err := newWebAuthn(f.Webauthn, f.Webauthn.RPID, origin)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	credential, err := web.ValidateLogin(u, *sessionData, parsedResp)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Warnf(
+			"WebAuthn: Clone warning detected for user %q / device %q. Device counter may be malfunctioning.", user, dev.GetName())
+	}
+	if f.AuthenticatorPolicy != nil {
+		if err := f.AuthenticatorPolicy.CheckLogin(aaguidString(credential.Authenticator.AAGUID)); err != nil {
+			return "", nil, trace.Wrap(err)
+		}
+	}
+
+	if err := setCounterAndTimestamps(dev, credential); err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	if err := f.Identity.UpsertMFADevice(ctx, user, dev); err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	if err := f.Identity.DeleteWebauthnSessionData(ctx, passwordlessUser, sessionID); err != nil {
+		log.Warnf("WebAuthn: failed to delete passwordless login SessionData for user %v", user)
+	}
+
+	return user, dev, nil
+}
+
+// challengeSessionID derives the session data storage key for a
+// passwordless login from its challenge, since passwordless SessionData
+// isn't scoped to any particular user.
+func challengeSessionID(sd *wantypes.SessionData) string {
+	return sd.Challenge
+}
+
+// resolveUserByWebauthnID performs the two-step lookup required for
+// passwordless login: first it resolves the Teleport username behind the
+// WebAuthn user handle returned in the assertion response, then it locates
+// the specific device that signed the challenge among that user's devices.
+func resolveUserByWebauthnID(ctx context.Context, identity LoginIdentity, webID, credentialID []byte) (string, *types.MFADevice, error) {
+	user, err := identity.GetTeleportUserByWebauthnID(ctx, webID)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+
+	devices, err := identity.GetMFADevices(ctx, user, false /* withSecrets */)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	dev, ok := findDeviceByID(devices, credentialID)
+	if !ok {
+		return "", nil, trace.BadParameter(
+			"unknown device credential: %q", base64.RawURLEncoding.EncodeToString(credentialID))
+	}
+	return user, dev, nil
+}
+
 func parseCredentialResponse(resp *CredentialAssertionResponse) (*protocol.ParsedCredentialAssertionData, error) {
 	// Do not pass extensions on to duo-labs/webauthn, they won't go past JSON
 	// unmarshal.
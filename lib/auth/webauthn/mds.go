@@ -0,0 +1,450 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webauthn
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// CertificationLevel is a FIDO Alliance authenticator certification level,
+// as reported by the Metadata Service.
+type CertificationLevel string
+
+// FIDO certification levels, from lowest to highest assurance. See the FIDO
+// Alliance's certification program for the authoritative list.
+const (
+	CertificationLevelNotCertified CertificationLevel = ""
+	CertificationLevelL1           CertificationLevel = "FIDO_CERTIFIED_L1"
+	CertificationLevelL1Plus       CertificationLevel = "FIDO_CERTIFIED_L1plus"
+	CertificationLevelL2           CertificationLevel = "FIDO_CERTIFIED_L2"
+	CertificationLevelL2Plus       CertificationLevel = "FIDO_CERTIFIED_L2plus"
+	CertificationLevelL3           CertificationLevel = "FIDO_CERTIFIED_L3"
+	CertificationLevelL3Plus       CertificationLevel = "FIDO_CERTIFIED_L3plus"
+)
+
+// certificationRank orders certification levels so "at least L2" style
+// policy checks can be expressed as integer comparisons.
+var certificationRank = map[CertificationLevel]int{
+	CertificationLevelNotCertified: 0,
+	CertificationLevelL1:           1,
+	CertificationLevelL1Plus:       2,
+	CertificationLevelL2:           3,
+	CertificationLevelL2Plus:       4,
+	CertificationLevelL3:           5,
+	CertificationLevelL3Plus:       6,
+}
+
+// AuthenticatorEntry is the subset of a FIDO MDS3 metadata statement that
+// Teleport's authenticator policy cares about.
+type AuthenticatorEntry struct {
+	// AAGUID identifies the authenticator model.
+	AAGUID string
+	// CertificationLevel is the highest FIDO certification status reported
+	// for this authenticator that hasn't been superseded by a revocation.
+	CertificationLevel CertificationLevel
+	// Revoked is true if the latest status report for this authenticator
+	// is a revocation (eg. due to a disclosed vulnerability).
+	Revoked bool
+}
+
+// mdsBlobPayload mirrors the subset of the FIDO MDS3 BLOB payload fields
+// Teleport parses. The full statement carries many more fields (icons,
+// supported extensions, etc.) that aren't relevant to policy decisions.
+type mdsBlobPayload struct {
+	NextUpdate string         `json:"nextUpdate"`
+	Entries    []mdsBlobEntry `json:"entries"`
+}
+
+type mdsBlobEntry struct {
+	AAGUID        string            `json:"aaguid"`
+	StatusReports []mdsStatusReport `json:"statusReports"`
+}
+
+type mdsStatusReport struct {
+	Status string `json:"status"`
+}
+
+// fidoRevokedStatuses are the MDS3 StatusReport.status values that Teleport
+// treats as "this authenticator must not be trusted anymore".
+var fidoRevokedStatuses = map[string]bool{
+	"REVOKED":                      true,
+	"USER_VERIFICATION_BYPASS":     true,
+	"ATTESTATION_KEY_COMPROMISE":   true,
+	"USER_KEY_REMOTE_COMPROMISE":   true,
+	"USER_KEY_PHYSICAL_COMPROMISE": true,
+}
+
+// fidoCertifiedStatuses maps MDS3 "*_CERTIFIED*" status values to a
+// CertificationLevel, ignoring non-certification statuses (eg.
+// "UPDATE_AVAILABLE").
+var fidoCertifiedStatuses = map[string]CertificationLevel{
+	"FIDO_CERTIFIED":        CertificationLevelL1,
+	"FIDO_CERTIFIED_L1":     CertificationLevelL1,
+	"FIDO_CERTIFIED_L1plus": CertificationLevelL1Plus,
+	"FIDO_CERTIFIED_L2":     CertificationLevelL2,
+	"FIDO_CERTIFIED_L2plus": CertificationLevelL2Plus,
+	"FIDO_CERTIFIED_L3":     CertificationLevelL3,
+	"FIDO_CERTIFIED_L3plus": CertificationLevelL3Plus,
+}
+
+func entryFromBlob(b mdsBlobEntry) AuthenticatorEntry {
+	entry := AuthenticatorEntry{AAGUID: b.AAGUID}
+	for _, report := range b.StatusReports {
+		if fidoRevokedStatuses[report.Status] {
+			entry.Revoked = true
+		}
+		if level, ok := fidoCertifiedStatuses[report.Status]; ok {
+			if certificationRank[level] > certificationRank[entry.CertificationLevel] {
+				entry.CertificationLevel = level
+			}
+		}
+	}
+	return entry
+}
+
+// MetadataSource fetches a signed FIDO MDS3 BLOB (a JWT whose payload lists
+// known authenticators and their certification/revocation status).
+type MetadataSource interface {
+	// FetchBlob returns the raw signed JWT blob.
+	FetchBlob(ctx context.Context) ([]byte, error)
+}
+
+// HTTPSMetadataSource fetches the MDS3 BLOB from the FIDO Alliance (or a
+// compatible mirror) over HTTPS.
+type HTTPSMetadataSource struct {
+	// URL is the metadata service endpoint, eg.
+	// "https://mds3.fidoalliance.org/".
+	URL string
+	// Client is the HTTP client used to fetch the blob. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+// FetchBlob implements MetadataSource.
+func (s *HTTPSMetadataSource) FetchBlob(ctx context.Context) ([]byte, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, trace.Errorf("fetching MDS3 blob: unexpected status %v", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return body, nil
+}
+
+// FileMetadataSource reads a previously-downloaded MDS3 BLOB from local
+// disk, for air-gapped installs that can't reach the FIDO Alliance servers.
+type FileMetadataSource struct {
+	// Path is the local filesystem path to the blob JWT.
+	Path string
+}
+
+// FetchBlob implements MetadataSource.
+func (s *FileMetadataSource) FetchBlob(ctx context.Context) ([]byte, error) {
+	body, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return body, nil
+}
+
+// AuthenticatorPolicyConfig configures an AuthenticatorPolicy.
+type AuthenticatorPolicyConfig struct {
+	// Source fetches the signed MDS3 blob.
+	Source MetadataSource
+	// RootCAs pins the FIDO MDS3 root (and any intermediate) certificates
+	// the blob's x5c signing chain must verify against. Without this, a
+	// blob's signing key is just whatever certificate the blob itself
+	// carries, so anyone able to serve a replacement blob could self-sign
+	// it and have it trusted.
+	RootCAs *x509.CertPool
+	// RequiredLevel is the minimum FIDO certification level an
+	// authenticator must hold to be accepted. Authenticators absent from
+	// the MDS blob are rejected whenever RequiredLevel is set.
+	RequiredLevel CertificationLevel
+	// AllowedAAGUIDs, if non-empty, restricts accepted authenticators to
+	// this explicit allow list (in addition to the certification check).
+	AllowedAAGUIDs []string
+	// DeniedAAGUIDs rejects authenticators matching any of these AAGUIDs,
+	// regardless of certification status.
+	DeniedAAGUIDs []string
+	// RefreshInterval bounds how often the blob is re-fetched even if
+	// nextUpdate is further away, and how often it's retried if parsing
+	// nextUpdate fails. Defaults to 24h.
+	RefreshInterval time.Duration
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *AuthenticatorPolicyConfig) CheckAndSetDefaults() error {
+	if c.Source == nil {
+		return trace.BadParameter("missing parameter Source")
+	}
+	if c.RootCAs == nil {
+		return trace.BadParameter("missing parameter RootCAs")
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = 24 * time.Hour
+	}
+	return nil
+}
+
+// AuthenticatorPolicy pins which WebAuthn authenticators are acceptable
+// based on a periodically-refreshed FIDO MDS3 blob, consulted at
+// registration time (and, for revocation, at every login).
+type AuthenticatorPolicy struct {
+	cfg AuthenticatorPolicyConfig
+
+	mu         sync.RWMutex
+	entries    map[string]AuthenticatorEntry
+	nextUpdate time.Time
+
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+// NewAuthenticatorPolicy creates an AuthenticatorPolicy and performs an
+// initial metadata fetch.
+func NewAuthenticatorPolicy(ctx context.Context, cfg AuthenticatorPolicyConfig) (*AuthenticatorPolicy, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	p := &AuthenticatorPolicy{
+		cfg:     cfg,
+		entries: make(map[string]AuthenticatorEntry),
+		allowed: toSet(cfg.AllowedAAGUIDs),
+		denied:  toSet(cfg.DeniedAAGUIDs),
+	}
+	if err := p.refresh(ctx); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return p, nil
+}
+
+func toSet(vals []string) map[string]bool {
+	set := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		set[v] = true
+	}
+	return set
+}
+
+// Run starts the background refresher, honoring the blob's nextUpdate
+// field (bounded by RefreshInterval so a bogus or far-future nextUpdate
+// doesn't stall refreshes indefinitely). It blocks until ctx is done, so
+// callers should invoke it in a goroutine.
+func (p *AuthenticatorPolicy) Run(ctx context.Context) {
+	for {
+		wait := p.cfg.RefreshInterval
+		p.mu.RLock()
+		nextUpdate := p.nextUpdate
+		p.mu.RUnlock()
+		if until := time.Until(nextUpdate); until > 0 && until < wait {
+			wait = until
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if err := p.refresh(ctx); err != nil {
+			log.WithError(err).Warn("Failed to refresh FIDO MDS3 metadata, keeping previous snapshot.")
+		}
+	}
+}
+
+// refresh fetches, verifies and parses the latest blob, replacing the
+// in-memory entry table on success.
+func (p *AuthenticatorPolicy) refresh(ctx context.Context) error {
+	raw, err := p.cfg.Source.FetchBlob(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	payload, err := verifyAndParseMDSBlob(raw, p.cfg.RootCAs)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	entries := make(map[string]AuthenticatorEntry, len(payload.Entries))
+	for _, e := range payload.Entries {
+		if e.AAGUID == "" {
+			continue
+		}
+		entries[e.AAGUID] = entryFromBlob(e)
+	}
+	nextUpdate, err := time.Parse("2006-01-02", payload.NextUpdate)
+	if err != nil {
+		nextUpdate = time.Now().Add(p.cfg.RefreshInterval)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries = entries
+	p.nextUpdate = nextUpdate
+	return nil
+}
+
+// CheckRegistration validates aaguid against the policy: it must be
+// certified at RequiredLevel or above (when set), must not be on the
+// revocation list, and must satisfy the allow/deny AAGUID lists.
+func (p *AuthenticatorPolicy) CheckRegistration(aaguid []byte) error {
+	return p.check(aaguidString(aaguid))
+}
+
+// CheckLogin re-validates a previously-registered authenticator at login
+// time, so a certification that was revoked after registration is still
+// enforced.
+func (p *AuthenticatorPolicy) CheckLogin(aaguid string) error {
+	return p.check(aaguid)
+}
+
+func (p *AuthenticatorPolicy) check(aaguid string) error {
+	if p.denied[aaguid] {
+		return trace.AccessDenied("authenticator %v is on the deny list", aaguid)
+	}
+	if len(p.allowed) > 0 && !p.allowed[aaguid] {
+		return trace.AccessDenied("authenticator %v is not on the allow list", aaguid)
+	}
+
+	p.mu.RLock()
+	entry, ok := p.entries[aaguid]
+	p.mu.RUnlock()
+
+	if !ok {
+		if p.cfg.RequiredLevel != CertificationLevelNotCertified {
+			return trace.AccessDenied("authenticator %v is not present in FIDO metadata", aaguid)
+		}
+		return nil
+	}
+	if entry.Revoked {
+		return trace.AccessDenied("authenticator %v has been revoked", aaguid)
+	}
+	if certificationRank[entry.CertificationLevel] < certificationRank[p.cfg.RequiredLevel] {
+		return trace.AccessDenied("authenticator %v certification level %v is below the required %v",
+			aaguid, entry.CertificationLevel, p.cfg.RequiredLevel)
+	}
+	return nil
+}
+
+// aaguidString formats a raw 16-byte AAGUID (as found in an attestation
+// object) as the canonical UUID string used as the key in MDS3 blob
+// entries, eg. "0132d4a0-99cf-11eb-8529-0242ac130003".
+func aaguidString(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return fmt.Sprintf("%x", aaguid)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", aaguid[0:4], aaguid[4:6], aaguid[6:8], aaguid[8:10], aaguid[10:16])
+}
+
+// verifyAndParseMDSBlob verifies the MDS3 blob's JWT signature against its
+// embedded x5c certificate chain, and verifies that chain against roots
+// (the pinned FIDO MDS3 root/intermediate certificates), before trusting
+// the leaf's public key. Without the chain-to-roots check, the blob's
+// signing key would just be whatever certificate the blob itself carries,
+// letting anyone able to serve a replacement blob self-sign it.
+func verifyAndParseMDSBlob(raw []byte, roots *x509.CertPool) (*mdsBlobPayload, error) {
+	var payload mdsBlobPayload
+	_, err := jwt.ParseWithClaims(string(raw), jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		rawCerts, _ := token.Header["x5c"].([]interface{})
+		if len(rawCerts) == 0 {
+			return nil, trace.BadParameter("MDS3 blob is missing x5c certificate chain")
+		}
+		chain := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, rawCert := range rawCerts {
+			der, err := base64.StdEncoding.DecodeString(rawCert.(string))
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			chain = append(chain, cert)
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+		leaf := chain[0]
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return nil, trace.AccessDenied("MDS3 blob signing certificate does not chain to a pinned root: %v", err)
+		}
+
+		return leaf.PublicKey, nil
+	})
+	if err != nil {
+		return nil, trace.Wrap(err, "verifying MDS3 blob signature")
+	}
+
+	// jwt.ParseWithClaims validated the signature; re-decode the payload
+	// segment into our richer struct rather than threading everything
+	// through jwt.MapClaims.
+	parts := splitJWT(string(raw))
+	if len(parts) != 3 {
+		return nil, trace.BadParameter("malformed MDS3 blob JWT")
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &payload, nil
+}
+
+func splitJWT(token string) []string {
+	var parts []string
+	start := 0
+	for i, c := range token {
+		if c == '.' {
+			parts = append(parts, token[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, token[start:])
+	return parts
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/sql/mgmt/2014-04-01/sql"
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/defaults"
+
+	awsarn "github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/redshift"
+	"github.com/gravitational/trace"
+	"google.golang.org/api/sqladmin/v1beta4"
+)
+
+// NewDatabaseFromRDSInstance creates a database resource from an RDS
+// instance, as returned by the RDS API. labelsFromTags projects selected
+// resource tags into labels, per RDSMatcher.LabelsFromTags. assumeRoleARN
+// is the IAM role (if any) that was assumed to discover instance, for
+// cross-account discovery; it's recorded so the IAM configurator grants
+// access in the right account.
+func NewDatabaseFromRDSInstance(instance *rds.DBInstance, labelsFromTags map[string]string, assumeRoleARN string) (types.Database, error) {
+	if instance.Endpoint == nil {
+		return nil, trace.BadParameter("RDS instance %v has no endpoint", rStringVal(instance.DBInstanceIdentifier))
+	}
+	protocol, err := rdsEngineProtocol(rStringVal(instance.Engine))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	region, err := regionFromARN(rStringVal(instance.DBInstanceArn))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return types.NewDatabaseV3(types.Metadata{
+		Name:   rStringVal(instance.DBInstanceIdentifier),
+		Labels: rdsTags(instance.TagList, labelsFromTags),
+	}, types.DatabaseSpecV3{
+		Protocol: protocol,
+		URI:      fmt.Sprintf("%v:%v", rStringVal(instance.Endpoint.Address), rInt64Val(instance.Endpoint.Port)),
+		AWS: types.AWS{
+			Region:        region,
+			AssumeRoleARN: assumeRoleARN,
+			RDS: types.RDS{
+				InstanceID: rStringVal(instance.DBInstanceIdentifier),
+				ResourceID: rStringVal(instance.DbiResourceId),
+			},
+		},
+	})
+}
+
+// NewDatabaseFromRDSCluster creates a database resource from an Aurora
+// cluster, as returned by the RDS API. See NewDatabaseFromRDSInstance for
+// labelsFromTags and assumeRoleARN.
+func NewDatabaseFromRDSCluster(cluster *rds.DBCluster, labelsFromTags map[string]string, assumeRoleARN string) (types.Database, error) {
+	if cluster.Endpoint == nil {
+		return nil, trace.BadParameter("Aurora cluster %v has no endpoint", rStringVal(cluster.DBClusterIdentifier))
+	}
+	protocol, err := rdsEngineProtocol(rStringVal(cluster.Engine))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	region, err := regionFromARN(rStringVal(cluster.DBClusterArn))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return types.NewDatabaseV3(types.Metadata{
+		Name:   rStringVal(cluster.DBClusterIdentifier),
+		Labels: rdsTags(cluster.TagList, labelsFromTags),
+	}, types.DatabaseSpecV3{
+		Protocol: protocol,
+		URI:      fmt.Sprintf("%v:%v", rStringVal(cluster.Endpoint), rInt64Val(cluster.Port)),
+		AWS: types.AWS{
+			Region:        region,
+			AssumeRoleARN: assumeRoleARN,
+			RDS: types.RDS{
+				ClusterID:  rStringVal(cluster.DBClusterIdentifier),
+				ResourceID: rStringVal(cluster.DbClusterResourceId),
+			},
+		},
+	})
+}
+
+// NewDatabaseFromRedshiftCluster creates a database resource from a
+// Redshift cluster, as returned by the Redshift API. Redshift speaks the
+// Postgres wire protocol, so it's proxied the same way Teleport proxies
+// Postgres. See NewDatabaseFromRDSInstance for labelsFromTags and
+// assumeRoleARN.
+func NewDatabaseFromRedshiftCluster(cluster *redshift.Cluster, labelsFromTags map[string]string, assumeRoleARN string) (types.Database, error) {
+	if cluster.Endpoint == nil {
+		return nil, trace.BadParameter("Redshift cluster %v has no endpoint", rStringVal(cluster.ClusterIdentifier))
+	}
+	region, err := regionFromARN(rStringVal(cluster.ClusterNamespaceArn))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return types.NewDatabaseV3(types.Metadata{
+		Name:   rStringVal(cluster.ClusterIdentifier),
+		Labels: redshiftTags(cluster.Tags, labelsFromTags),
+	}, types.DatabaseSpecV3{
+		Protocol: defaults.ProtocolPostgres,
+		URI:      fmt.Sprintf("%v:%v", rStringVal(cluster.Endpoint.Address), rInt64Val(cluster.Endpoint.Port)),
+		AWS: types.AWS{
+			Region:        region,
+			AssumeRoleARN: assumeRoleARN,
+			Redshift: types.Redshift{
+				ClusterID: rStringVal(cluster.ClusterIdentifier),
+			},
+		},
+	})
+}
+
+// rdsEngineProtocol maps an RDS/Aurora engine name to the database protocol
+// Teleport proxies for it.
+func rdsEngineProtocol(engine string) (string, error) {
+	switch {
+	case strings.HasPrefix(engine, "postgres"), strings.HasPrefix(engine, "aurora-postgresql"):
+		return defaults.ProtocolPostgres, nil
+	case strings.HasPrefix(engine, "mysql"), strings.HasPrefix(engine, "mariadb"), strings.HasPrefix(engine, "aurora-mysql"), engine == "aurora":
+		return defaults.ProtocolMySQL, nil
+	}
+	return "", trace.BadParameter("unsupported RDS engine %q", engine)
+}
+
+// regionFromARN extracts the AWS region from a resource ARN.
+func regionFromARN(resourceARN string) (string, error) {
+	parsed, err := awsarn.Parse(resourceARN)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return parsed.Region, nil
+}
+
+// rdsTags converts RDS's pointer-valued resource tags to the plain
+// map[string]string used for resource labels, projecting labelsFromTags on
+// top (see RDSMatcher.LabelsFromTags).
+func rdsTags(tags []*rds.Tag, labelsFromTags map[string]string) map[string]string {
+	raw := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		raw[rStringVal(tag.Key)] = rStringVal(tag.Value)
+	}
+	return LabelsFromTags(labelsFromTags, raw)
+}
+
+// redshiftTags converts Redshift's pointer-valued resource tags to the
+// plain map[string]string used for resource labels, projecting
+// labelsFromTags on top (see RedshiftMatcher.LabelsFromTags).
+func redshiftTags(tags []*redshift.Tag, labelsFromTags map[string]string) map[string]string {
+	raw := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		raw[rStringVal(tag.Key)] = rStringVal(tag.Value)
+	}
+	return LabelsFromTags(labelsFromTags, raw)
+}
+
+// rStringVal dereferences a string pointer, returning "" for nil.
+func rStringVal(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// rInt64Val dereferences an int64 pointer, returning 0 for nil.
+func rInt64Val(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// NewDatabaseFromAzureSQLServer creates a database resource from an Azure
+// SQL server, as returned by the Azure SQL management API.
+func NewDatabaseFromAzureSQLServer(server sql.Server) (types.Database, error) {
+	return types.NewDatabaseV3(types.Metadata{
+		Name:   aStringVal(server.Name),
+		Labels: azureTags(server.Tags),
+	}, types.DatabaseSpecV3{
+		Protocol: defaults.ProtocolSQLServer,
+		URI:      fmt.Sprintf("%v:1433", aStringVal(server.FullyQualifiedDomainName)),
+		Azure: types.Azure{
+			Name:       aStringVal(server.Name),
+			ResourceID: aStringVal(server.ID),
+		},
+	})
+}
+
+// NewDatabaseFromGCPSQLInstance creates a database resource from a GCP
+// Cloud SQL instance, as returned by the Cloud SQL Admin API.
+func NewDatabaseFromGCPSQLInstance(instance *sqladmin.DatabaseInstance, projectID string) (types.Database, error) {
+	if len(instance.IpAddresses) == 0 {
+		return nil, trace.BadParameter("Cloud SQL instance %v has no IP addresses", instance.Name)
+	}
+	protocol, err := gcpSQLProtocol(instance.DatabaseVersion)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return types.NewDatabaseV3(types.Metadata{
+		Name:   instance.Name,
+		Labels: instance.Settings.UserLabels,
+	}, types.DatabaseSpecV3{
+		Protocol: protocol,
+		URI:      fmt.Sprintf("%v:%v", instance.IpAddresses[0].IpAddress, gcpSQLPort(protocol)),
+		GCP: types.GCPCloudSQL{
+			ProjectID:  projectID,
+			InstanceID: instance.Name,
+		},
+	})
+}
+
+// gcpSQLProtocol maps a Cloud SQL database engine version to the database
+// protocol Teleport proxies for it.
+func gcpSQLProtocol(databaseVersion string) (string, error) {
+	switch {
+	case strings.HasPrefix(databaseVersion, "POSTGRES"):
+		return defaults.ProtocolPostgres, nil
+	case strings.HasPrefix(databaseVersion, "MYSQL"):
+		return defaults.ProtocolMySQL, nil
+	}
+	return "", trace.BadParameter("unsupported Cloud SQL database version %q", databaseVersion)
+}
+
+// gcpSQLPort returns the default port for the given database protocol.
+func gcpSQLPort(protocol string) int {
+	if protocol == defaults.ProtocolMySQL {
+		return 3306
+	}
+	return 5432
+}
+
+// azureTags converts Azure's pointer-valued resource tags to the plain
+// map[string]string used for resource labels.
+func azureTags(tags map[string]*string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for k, v := range tags {
+		labels[k] = aStringVal(v)
+	}
+	return labels
+}
+
+// aStringVal dereferences a string pointer, returning "" for nil.
+func aStringVal(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
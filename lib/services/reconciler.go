@@ -0,0 +1,376 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/api/types"
+
+	"github.com/gravitational/trace"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ReconcilerConfig is the resource reconciler configuration.
+type ReconcilerConfig struct {
+	// Matcher is used to match resources.
+	Matcher func(types.ResourceWithLabels) bool
+	// GetResources returns currently registered resources.
+	GetResources func() types.ResourcesWithLabels
+	// OnCreate is called when a new resource is detected.
+	OnCreate func(context.Context, types.ResourceWithLabels) error
+	// OnUpdate is called when an existing resource is updated.
+	OnUpdate func(context.Context, types.ResourceWithLabels) error
+	// OnDelete is called when an existing resource is deleted.
+	OnDelete func(context.Context, types.ResourceWithLabels) error
+	// OnError is called when a resource exhausts MaxRequeues attempts.
+	// It is optional - errors are always logged regardless.
+	OnError func(op string, resource types.ResourceWithLabels, err error)
+	// ManagedFields restricts reconciliation on update to the named
+	// top-level struct fields: only those fields are compared for changes,
+	// and only those fields are copied onto the resource passed to
+	// OnUpdate, leaving every other field as it was on the currently
+	// registered resource. This lets the reconciler own, eg. discovered
+	// labels and a connection endpoint, without overwriting fields a user
+	// set by other means (direct API/tctl edits).
+	//
+	// If empty, Reconcile falls back to full-object replacement: any
+	// difference between the current and desired resource triggers an
+	// update, and OnUpdate receives the desired resource verbatim.
+	ManagedFields []string
+	// Workers is the number of goroutines draining the reconciler's work
+	// queue. Defaults to 5.
+	Workers int
+	// MaxRequeues is how many times a failed reconciliation of a given
+	// resource is retried (with exponential backoff) before it is
+	// abandoned and surfaced via OnError. Defaults to 15.
+	MaxRequeues int
+	// Log is the reconciler's logger.
+	Log logrus.FieldLogger
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *ReconcilerConfig) CheckAndSetDefaults() error {
+	if c.Matcher == nil {
+		return trace.BadParameter("missing parameter Matcher")
+	}
+	if c.GetResources == nil {
+		return trace.BadParameter("missing parameter GetResources")
+	}
+	if c.OnCreate == nil {
+		return trace.BadParameter("missing parameter OnCreate")
+	}
+	if c.OnUpdate == nil {
+		return trace.BadParameter("missing parameter OnUpdate")
+	}
+	if c.OnDelete == nil {
+		return trace.BadParameter("missing parameter OnDelete")
+	}
+	if c.Workers == 0 {
+		c.Workers = 5
+	}
+	if c.MaxRequeues == 0 {
+		c.MaxRequeues = 15
+	}
+	if c.Log == nil {
+		c.Log = logrus.WithField(trace.Component, "reconciler")
+	}
+	return nil
+}
+
+// Reconciler reconciles the provided resources with the desired state,
+// creating/updating/deleting them via the configured callbacks.
+//
+// Each Reconcile call diffs the desired state against the currently
+// registered resources and enqueues a (kind, name, op) item per change onto
+// a per-key work queue. A pool of workers drains the queue; a failing
+// callback is retried with exponential backoff, up to MaxRequeues times, and
+// a rapid stream of updates for the same resource collapses into the latest
+// desired state instead of being processed one at a time.
+type Reconciler struct {
+	cfg   ReconcilerConfig
+	queue *reconcilerQueue
+
+	startOnce sync.Once
+
+	metrics reconcilerMetrics
+}
+
+// NewReconciler creates a new reconciler from the provided config.
+func NewReconciler(cfg ReconcilerConfig) (*Reconciler, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Reconciler{
+		cfg:     cfg,
+		queue:   newReconcilerQueue(),
+		metrics: newReconcilerMetrics(),
+	}, nil
+}
+
+// reconcileOp is the kind of change a work item represents.
+type reconcileOp string
+
+const (
+	reconcileOpCreate reconcileOp = "create"
+	reconcileOpUpdate reconcileOp = "update"
+	reconcileOpDelete reconcileOp = "delete"
+)
+
+// Reconcile reconciles currently registered resources with the provided
+// list of resources and creates/updates/deletes resources via the
+// configured callbacks. It returns once every changed resource has had its
+// first reconciliation attempt; a failing attempt is retried in the
+// background by the worker pool and does not block the caller.
+func (r *Reconciler) Reconcile(ctx context.Context, newResources types.ResourcesWithLabels) error {
+	r.startOnce.Do(func() { r.startWorkers(ctx) })
+
+	current := make(map[string]types.ResourceWithLabels, len(r.cfg.GetResources()))
+	for _, resource := range r.cfg.GetResources() {
+		current[resource.GetName()] = resource
+	}
+
+	var wg sync.WaitGroup
+	matched := make(map[string]bool, len(newResources))
+	for _, newResource := range newResources {
+		if !r.cfg.Matcher(newResource) {
+			continue
+		}
+		matched[newResource.GetName()] = true
+
+		currentResource, exists := current[newResource.GetName()]
+		if !exists {
+			r.cfg.Log.Debugf("%v %v is new, creating.", logPrefix(newResource), newResource.GetName())
+			r.enqueue(&wg, reconcileOpCreate, newResource)
+			continue
+		}
+		if currentResource.Origin() != newResource.Origin() {
+			// Don't let resources registered via a different origin
+			// overwrite one another (e.g. static config file vs.
+			// dynamic resource vs. cloud-discovered).
+			continue
+		}
+
+		updated, changed, err := r.diff(currentResource, newResource)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if !changed {
+			continue
+		}
+		r.cfg.Log.Debugf("%v %v has changed, updating.", logPrefix(newResource), newResource.GetName())
+		r.enqueue(&wg, reconcileOpUpdate, updated)
+	}
+
+	for _, currentResource := range current {
+		if matched[currentResource.GetName()] {
+			continue
+		}
+		if !r.cfg.Matcher(currentResource) {
+			continue
+		}
+		r.cfg.Log.Debugf("%v %v no longer matches, deleting.", logPrefix(currentResource), currentResource.GetName())
+		r.enqueue(&wg, reconcileOpDelete, currentResource)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// diff compares current against newResource, returning the resource that
+// should be passed to OnUpdate and whether an update is needed at all. With
+// ManagedFields unset it falls back to comparing (and replacing) the whole
+// resource; otherwise only the managed fields are compared and copied.
+func (r *Reconciler) diff(current, newResource types.ResourceWithLabels) (types.ResourceWithLabels, bool, error) {
+	if len(r.cfg.ManagedFields) == 0 {
+		return newResource, !reflect.DeepEqual(current, newResource), nil
+	}
+	changed, err := managedFieldsChanged(current, newResource, r.cfg.ManagedFields)
+	if err != nil {
+		return nil, false, trace.Wrap(err)
+	}
+	if !changed {
+		return nil, false, nil
+	}
+	merged, err := mergeManagedFields(current, newResource, r.cfg.ManagedFields)
+	if err != nil {
+		return nil, false, trace.Wrap(err)
+	}
+	return merged, true, nil
+}
+
+// enqueue adds a work item to the queue, registering a done callback that
+// decrements wg once the item has had its first processing attempt.
+func (r *Reconciler) enqueue(wg *sync.WaitGroup, op reconcileOp, resource types.ResourceWithLabels) {
+	wg.Add(1)
+	r.queue.add(workItem{
+		key:      resourceKey(resource),
+		op:       op,
+		resource: resource,
+	}, wg.Done)
+	r.metrics.observeQueueDepth(r.queue.len())
+}
+
+// startWorkers launches the configured number of queue-draining workers.
+// Workers run until ctx is done.
+func (r *Reconciler) startWorkers(ctx context.Context) {
+	for i := 0; i < r.cfg.Workers; i++ {
+		go r.worker(ctx)
+	}
+	go func() {
+		<-ctx.Done()
+		r.queue.close()
+	}()
+}
+
+// worker drains the queue, invoking the appropriate callback for each item
+// and handling retry/backoff and give-up bookkeeping.
+func (r *Reconciler) worker(ctx context.Context) {
+	for {
+		it, dones, ok := r.queue.get()
+		if !ok {
+			return
+		}
+		r.metrics.observeQueueDepth(r.queue.len())
+
+		start := time.Now()
+		err := r.process(ctx, it)
+		r.metrics.observeLatency(it.op, time.Since(start))
+
+		if err != nil && it.requeues < r.cfg.MaxRequeues {
+			it.requeues++
+			r.cfg.Log.WithError(err).Warnf("Failed to reconcile %v %v, retrying (%v/%v).",
+				it.op, it.key, it.requeues, r.cfg.MaxRequeues)
+			r.queue.done(it.key)
+			r.queue.addAfter(it, requeueBackoff(it.requeues))
+			for _, done := range dones {
+				done()
+			}
+			continue
+		}
+
+		if err != nil {
+			r.cfg.Log.WithError(err).Errorf("Giving up reconciling %v %v after %v attempts.",
+				it.op, it.key, it.requeues)
+			r.metrics.observeResult(it.op, false)
+			if r.cfg.OnError != nil {
+				r.cfg.OnError(string(it.op), it.resource, err)
+			}
+		} else {
+			r.metrics.observeResult(it.op, true)
+		}
+		r.queue.done(it.key)
+		for _, done := range dones {
+			done()
+		}
+	}
+}
+
+// process invokes the callback matching the work item's operation.
+func (r *Reconciler) process(ctx context.Context, it workItem) error {
+	switch it.op {
+	case reconcileOpCreate:
+		return r.cfg.OnCreate(ctx, it.resource)
+	case reconcileOpUpdate:
+		return r.cfg.OnUpdate(ctx, it.resource)
+	case reconcileOpDelete:
+		return r.cfg.OnDelete(ctx, it.resource)
+	default:
+		return trace.BadParameter("unknown reconcile op %q", it.op)
+	}
+}
+
+// requeueBackoff returns the exponential backoff delay for the nth requeue.
+func requeueBackoff(requeues int) time.Duration {
+	const (
+		base = 100 * time.Millisecond
+		max  = 30 * time.Second
+	)
+	backoff := base << uint(requeues)
+	if backoff <= 0 || backoff > max { // overflow or past cap
+		return max
+	}
+	return backoff
+}
+
+// resourceKey returns the work queue deduplication key for a resource.
+func resourceKey(resource types.ResourceWithLabels) string {
+	return resource.GetName()
+}
+
+func logPrefix(resource types.ResourceWithLabels) string {
+	return reflect.TypeOf(resource).String()
+}
+
+// reconcilerMetrics are the Prometheus metrics exported by a Reconciler.
+type reconcilerMetrics struct {
+	queueDepth prometheus.Gauge
+	latency    *prometheus.HistogramVec
+	results    *prometheus.CounterVec
+}
+
+func newReconcilerMetrics() reconcilerMetrics {
+	m := reconcilerMetrics{
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: teleport.MetricNamespace,
+			Name:      "reconciler_queue_depth",
+			Help:      "Number of items waiting in the reconciler work queue",
+		}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: teleport.MetricNamespace,
+			Name:      "reconciler_latency_seconds",
+			Help:      "Time taken to process a single reconcile operation",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op"}),
+		results: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: teleport.MetricNamespace,
+			Name:      "reconciler_results_total",
+			Help:      "Count of reconcile operations by op and outcome",
+		}, []string{"op", "success"}),
+	}
+	// Registration failures (e.g. duplicate registration from multiple
+	// reconciler instances in tests) are not fatal - the metrics are
+	// best-effort observability, not correctness-critical.
+	_ = prometheus.Register(m.queueDepth)
+	_ = prometheus.Register(m.latency)
+	_ = prometheus.Register(m.results)
+	return m
+}
+
+func (m reconcilerMetrics) observeQueueDepth(depth int) {
+	m.queueDepth.Set(float64(depth))
+}
+
+func (m reconcilerMetrics) observeLatency(op reconcileOp, d time.Duration) {
+	m.latency.WithLabelValues(string(op)).Observe(d.Seconds())
+}
+
+func (m reconcilerMetrics) observeResult(op reconcileOp, success bool) {
+	m.results.WithLabelValues(string(op), boolLabel(success)).Inc()
+}
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/gravitational/teleport/api/types"
+
+	"github.com/gravitational/trace"
+)
+
+// managedFieldsChanged reports whether any of fields differs between current
+// and newResource. Unlike reflect.DeepEqual(current, newResource), drift in
+// fields outside of the list (eg. state set by something other than the
+// reconciler) doesn't count as a change.
+func managedFieldsChanged(current, newResource types.ResourceWithLabels, fields []string) (bool, error) {
+	currentFields, err := reflectFields(current, fields)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	newFields, err := reflectFields(newResource, fields)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	for i := range fields {
+		if !reflect.DeepEqual(currentFields[i].Interface(), newFields[i].Interface()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mergeManagedFields returns a copy of current with fields overwritten from
+// newResource, leaving every other field of current untouched. This lets a
+// reconciler own a subset of a resource (eg. the cloud-discovered labels and
+// connection endpoint) without clobbering fields managed elsewhere (eg. a
+// user-assigned description edited directly via tctl).
+func mergeManagedFields(current, newResource types.ResourceWithLabels, fields []string) (types.ResourceWithLabels, error) {
+	currentVal := reflect.ValueOf(current)
+	if currentVal.Kind() != reflect.Ptr || currentVal.Elem().Kind() != reflect.Struct {
+		return nil, trace.BadParameter("cannot merge managed fields of non-struct resource %T", current)
+	}
+	if reflect.TypeOf(current) != reflect.TypeOf(newResource) {
+		return nil, trace.BadParameter("cannot merge managed fields between mismatched resource types %T and %T",
+			current, newResource)
+	}
+
+	merged := reflect.New(currentVal.Elem().Type())
+	merged.Elem().Set(currentVal.Elem())
+
+	newVal := reflect.ValueOf(newResource)
+	for _, field := range fields {
+		dst, err := resolveFieldPath(merged.Elem(), field)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		src, err := resolveFieldPath(newVal.Elem(), field)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if !dst.CanSet() {
+			return nil, trace.BadParameter("managed field %q on %T cannot be set", field, current)
+		}
+		dst.Set(src)
+	}
+
+	resource, ok := merged.Interface().(types.ResourceWithLabels)
+	if !ok {
+		return nil, trace.BadParameter("merged %T does not implement ResourceWithLabels", current)
+	}
+	return resource, nil
+}
+
+// reflectFields returns the reflect.Value of each named field on resource,
+// which must be a pointer to a struct. A field name may be dotted (eg.
+// "Spec.AWS.RDS.ClusterID") to reach a field nested inside an embedded or
+// named struct field; each dotted segment is resolved with resolveFieldPath.
+func reflectFields(resource types.ResourceWithLabels, fields []string) ([]reflect.Value, error) {
+	val := reflect.ValueOf(resource)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return nil, trace.BadParameter("cannot diff fields of non-struct resource %T", resource)
+	}
+	val = val.Elem()
+
+	values := make([]reflect.Value, len(fields))
+	for i, field := range fields {
+		fv, err := resolveFieldPath(val, field)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		values[i] = fv
+	}
+	return values, nil
+}
+
+// resolveFieldPath walks val, a struct value, through each dot-separated
+// segment of path (eg. "Spec.AWS.RDS.ClusterID"), dereferencing pointer
+// fields encountered along the way. It fails if any segment names an unknown
+// field or a nil pointer is encountered before the path is fully resolved.
+func resolveFieldPath(val reflect.Value, path string) (reflect.Value, error) {
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		for val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				return reflect.Value{}, trace.BadParameter(
+					"managed field %q: nil pointer at %q", path, strings.Join(segments[:i], "."))
+			}
+			val = val.Elem()
+		}
+		if val.Kind() != reflect.Struct {
+			return reflect.Value{}, trace.BadParameter("unknown managed field %q", path)
+		}
+		val = val.FieldByName(segment)
+		if !val.IsValid() {
+			return reflect.Value{}, trace.BadParameter("unknown managed field %q", path)
+		}
+	}
+	return val, nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport/api/types"
+)
+
+// workItem is a single unit of reconciliation work.
+type workItem struct {
+	// key uniquely identifies the resource within the queue, used for
+	// deduplication/coalescing.
+	key string
+	// op is the operation to perform.
+	op reconcileOp
+	// resource is the desired state to reconcile towards.
+	resource types.ResourceWithLabels
+	// requeues counts how many times this item has already been retried.
+	requeues int
+}
+
+// reconcilerQueue is a small per-key rate-limited work queue modeled after
+// the controller-runtime/client-go workqueue: items are deduplicated by key
+// so a rapid stream of updates for the same resource collapses to the
+// latest desired state, and a key already being processed is re-queued
+// (rather than processed concurrently) once its worker finishes.
+type reconcilerQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// order is the FIFO of keys waiting to be (re)dispatched to a worker.
+	order []string
+	// items holds the latest work item seen for each key.
+	items map[string]workItem
+	// dones holds the pending completion callbacks for each key, one per
+	// Reconcile() call that enqueued work for that key since it was last
+	// fully processed.
+	dones map[string][]func()
+	// queued tracks keys present in order, to avoid double-queuing.
+	queued map[string]bool
+	// processing tracks keys currently checked out by a worker.
+	processing map[string]bool
+
+	closed bool
+}
+
+func newReconcilerQueue() *reconcilerQueue {
+	q := &reconcilerQueue{
+		items:      make(map[string]workItem),
+		dones:      make(map[string][]func()),
+		queued:     make(map[string]bool),
+		processing: make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// add enqueues a work item, coalescing with any already-pending item for
+// the same key. done, if non-nil, is called exactly once this item (or
+// whatever superseded it) has had its next processing attempt.
+func (q *reconcilerQueue) add(it workItem, done func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	// A freshly-enqueued item represents new desired state, not a retry,
+	// so it starts its own backoff count over.
+	it.requeues = 0
+	q.items[it.key] = it
+	if done != nil {
+		q.dones[it.key] = append(q.dones[it.key], done)
+	}
+	if q.queued[it.key] {
+		return // already waiting in order, will pick up the latest item
+	}
+	if q.processing[it.key] {
+		// Will be re-queued by done() once the in-flight attempt finishes.
+		return
+	}
+	q.queued[it.key] = true
+	q.order = append(q.order, it.key)
+	q.cond.Signal()
+}
+
+// addAfter schedules an internal retry of it after delay. Unlike add, it
+// does not take a done callback - it's a continuation of an attempt that
+// has already been "done" once from the caller's perspective.
+func (q *reconcilerQueue) addAfter(it workItem, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.closed {
+			return
+		}
+		// If a newer generation has already arrived for this key (via an
+		// external add()) since this retry was scheduled, let that state
+		// win rather than resurrecting the stale retry payload.
+		if _, exists := q.items[it.key]; !exists {
+			q.items[it.key] = it
+		}
+		if q.queued[it.key] || q.processing[it.key] {
+			return
+		}
+		q.queued[it.key] = true
+		q.order = append(q.order, it.key)
+		q.cond.Signal()
+	})
+}
+
+// get blocks until a key is available, checks it out for processing, and
+// returns its current work item along with the completion callbacks
+// registered against it.
+func (q *reconcilerQueue) get() (workItem, []func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.order) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.order) == 0 {
+		return workItem{}, nil, false
+	}
+	key := q.order[0]
+	q.order = q.order[1:]
+	delete(q.queued, key)
+	q.processing[key] = true
+
+	it := q.items[key]
+	dones := q.dones[key]
+	delete(q.dones, key)
+	return it, dones, true
+}
+
+// done marks key as no longer being processed. If new work arrived for the
+// key while it was in flight, it's re-queued for another pass; otherwise
+// its state is dropped.
+func (q *reconcilerQueue) done(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.processing, key)
+	if len(q.dones[key]) > 0 && !q.queued[key] {
+		q.queued[key] = true
+		q.order = append(q.order, key)
+		q.cond.Signal()
+		return
+	}
+	if !q.queued[key] {
+		delete(q.items, key)
+	}
+}
+
+// len returns the number of keys currently waiting to be dispatched.
+func (q *reconcilerQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order)
+}
+
+// close stops the queue; blocked get() calls return false.
+func (q *reconcilerQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
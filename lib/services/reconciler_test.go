@@ -18,10 +18,15 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gravitational/teleport/api/types"
 
+	"github.com/gravitational/trace"
 	"github.com/stretchr/testify/require"
 )
 
@@ -150,6 +155,10 @@ func TestReconciler(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
 			// Reconciler will record all callback calls in these lists.
+			// The reconciler now dispatches callbacks from a pool of
+			// worker goroutines, so appends must be synchronized and
+			// call order is no longer guaranteed to match enqueue order.
+			var mu sync.Mutex
 			var onCreateCalls, onUpdateCalls, onDeleteCalls types.ResourcesWithLabels
 
 			reconciler, err := NewReconciler(ReconcilerConfig{
@@ -160,14 +169,20 @@ func TestReconciler(t *testing.T) {
 					return test.registeredResources
 				},
 				OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error {
+					mu.Lock()
+					defer mu.Unlock()
 					onCreateCalls = append(onCreateCalls, r)
 					return nil
 				},
 				OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error {
+					mu.Lock()
+					defer mu.Unlock()
 					onUpdateCalls = append(onUpdateCalls, r)
 					return nil
 				},
 				OnDelete: func(ctx context.Context, r types.ResourceWithLabels) error {
+					mu.Lock()
+					defer mu.Unlock()
 					onDeleteCalls = append(onDeleteCalls, r)
 					return nil
 				},
@@ -177,9 +192,11 @@ func TestReconciler(t *testing.T) {
 			// Reconcile and make sure we got all expected callback calls.
 			err = reconciler.Reconcile(context.Background(), test.newResources)
 			require.NoError(t, err)
-			require.Equal(t, test.onCreateCalls, onCreateCalls)
-			require.Equal(t, test.onUpdateCalls, onUpdateCalls)
-			require.Equal(t, test.onDeleteCalls, onDeleteCalls)
+			mu.Lock()
+			defer mu.Unlock()
+			require.ElementsMatch(t, test.onCreateCalls, onCreateCalls)
+			require.ElementsMatch(t, test.onUpdateCalls, onUpdateCalls)
+			require.ElementsMatch(t, test.onDeleteCalls, onDeleteCalls)
 		})
 	}
 }
@@ -227,3 +244,271 @@ func (r *testResource) Origin() string {
 func (r *testResource) GetAllLabels() map[string]string {
 	return r.Metadata.Labels
 }
+
+// testResourceWithExtra is like testResource but carries an additional
+// field that reconciliation doesn't own, standing in for state set by
+// something other than the reconciler (eg. a user edit via tctl).
+type testResourceWithExtra struct {
+	types.ResourceWithLabels
+	Metadata types.Metadata
+	Extra    string
+}
+
+func (r *testResourceWithExtra) GetName() string {
+	return r.Metadata.Name
+}
+
+func (r *testResourceWithExtra) Origin() string {
+	return r.Metadata.Labels[types.OriginLabel]
+}
+
+func (r *testResourceWithExtra) GetAllLabels() map[string]string {
+	return r.Metadata.Labels
+}
+
+func makeResourceWithExtra(name, extra string, labels map[string]string) *testResourceWithExtra {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[types.OriginLabel] = types.OriginDynamic
+	return &testResourceWithExtra{
+		Metadata: types.Metadata{Name: name, Labels: labels},
+		Extra:    extra,
+	}
+}
+
+// TestReconcilerManagedFields verifies that, with ManagedFields set, only
+// those fields are compared and copied on update - a resource that differs
+// only in an unmanaged field is left alone, and an update that does touch a
+// managed field preserves the unmanaged ones.
+func TestReconcilerManagedFields(t *testing.T) {
+	t.Parallel()
+
+	t.Run("change outside managed fields doesn't trigger an update", func(t *testing.T) {
+		registered := makeResourceWithExtra("res1", "user-set", map[string]string{"env": "prod"})
+
+		reconciler, err := NewReconciler(ReconcilerConfig{
+			Matcher: func(rwl types.ResourceWithLabels) bool { return true },
+			GetResources: func() types.ResourcesWithLabels {
+				return types.ResourcesWithLabels{registered}
+			},
+			OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+			OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error {
+				t.Fatal("OnUpdate should not be called when only an unmanaged field differs")
+				return nil
+			},
+			OnDelete:      func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+			ManagedFields: []string{"Metadata"},
+		})
+		require.NoError(t, err)
+
+		err = reconciler.Reconcile(context.Background(), types.ResourcesWithLabels{
+			makeResourceWithExtra("res1", "reconciler-value", map[string]string{"env": "prod"}),
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("update to a managed field preserves unmanaged ones", func(t *testing.T) {
+		registered := makeResourceWithExtra("res1", "user-set", map[string]string{"env": "prod"})
+
+		var updated *testResourceWithExtra
+		reconciler, err := NewReconciler(ReconcilerConfig{
+			Matcher: func(rwl types.ResourceWithLabels) bool { return true },
+			GetResources: func() types.ResourcesWithLabels {
+				return types.ResourcesWithLabels{registered}
+			},
+			OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+			OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error {
+				updated = r.(*testResourceWithExtra)
+				return nil
+			},
+			OnDelete:      func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+			ManagedFields: []string{"Metadata"},
+		})
+		require.NoError(t, err)
+
+		err = reconciler.Reconcile(context.Background(), types.ResourcesWithLabels{
+			makeResourceWithExtra("res1", "reconciler-value", map[string]string{"env": "dev"}),
+		})
+		require.NoError(t, err)
+
+		require.NotNil(t, updated)
+		require.Equal(t, "dev", updated.GetAllLabels()["env"])
+		require.Equal(t, "user-set", updated.Extra)
+	})
+
+	t.Run("dotted path manages a nested field without touching its siblings", func(t *testing.T) {
+		registered := makeResourceWithExtra("res1", "user-set", map[string]string{"env": "prod"})
+
+		var updated *testResourceWithExtra
+		reconciler, err := NewReconciler(ReconcilerConfig{
+			Matcher: func(rwl types.ResourceWithLabels) bool { return true },
+			GetResources: func() types.ResourcesWithLabels {
+				return types.ResourcesWithLabels{registered}
+			},
+			OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+			OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error {
+				updated = r.(*testResourceWithExtra)
+				return nil
+			},
+			OnDelete:      func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+			ManagedFields: []string{"Metadata.Labels"},
+		})
+		require.NoError(t, err)
+
+		err = reconciler.Reconcile(context.Background(), types.ResourcesWithLabels{
+			makeResourceWithExtra("res1", "reconciler-value", map[string]string{"env": "dev"}),
+		})
+		require.NoError(t, err)
+
+		require.NotNil(t, updated)
+		require.Equal(t, "dev", updated.GetAllLabels()["env"])
+		require.Equal(t, "user-set", updated.Extra)
+		require.Equal(t, "res1", updated.GetName(), "Metadata.Name is a sibling of Metadata.Labels and must survive untouched")
+	})
+}
+
+// TestReconcilerRetry verifies that a failing OnCreate is retried rather
+// than aborting the rest of the batch, and that OnError is invoked once
+// MaxRequeues is exhausted.
+func TestReconcilerRetry(t *testing.T) {
+	t.Parallel()
+
+	const failName = "res-flaky"
+	var attempts int32
+
+	errC := make(chan struct{}, 1)
+	reconciler, err := NewReconciler(ReconcilerConfig{
+		Matcher: func(rwl types.ResourceWithLabels) bool { return true },
+		GetResources: func() types.ResourcesWithLabels {
+			return types.ResourcesWithLabels{}
+		},
+		OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error {
+			if r.GetName() == failName {
+				atomic.AddInt32(&attempts, 1)
+				return trace.Errorf("simulated failure")
+			}
+			return nil
+		},
+		OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+		OnDelete: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+		OnError: func(op string, r types.ResourceWithLabels, err error) {
+			errC <- struct{}{}
+		},
+		MaxRequeues: 2,
+	})
+	require.NoError(t, err)
+
+	err = reconciler.Reconcile(context.Background(), types.ResourcesWithLabels{
+		makeDynamicResource(failName, nil),
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-errC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnError after exhausting retries")
+	}
+	require.GreaterOrEqual(t, int(atomic.LoadInt32(&attempts)), 1)
+}
+
+// TestReconcilerConcurrentWorkers verifies that independent resources are
+// reconciled concurrently by the worker pool.
+func TestReconcilerConcurrentWorkers(t *testing.T) {
+	t.Parallel()
+
+	const workers = 4
+	var inFlight, maxInFlight int32
+	var mu sync.Mutex
+
+	reconciler, err := NewReconciler(ReconcilerConfig{
+		Matcher: func(rwl types.ResourceWithLabels) bool { return true },
+		GetResources: func() types.ResourcesWithLabels {
+			return types.ResourcesWithLabels{}
+		},
+		OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error {
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			mu.Lock()
+			if current > maxInFlight {
+				maxInFlight = current
+			}
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		},
+		OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+		OnDelete: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+		Workers:  workers,
+	})
+	require.NoError(t, err)
+
+	var newResources types.ResourcesWithLabels
+	for i := 0; i < workers*2; i++ {
+		newResources = append(newResources, makeDynamicResource(fmt.Sprintf("res-%d", i), nil))
+	}
+
+	err = reconciler.Reconcile(context.Background(), newResources)
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Greater(t, int(maxInFlight), 1, "expected more than one resource to be reconciled concurrently")
+}
+
+// TestReconcilerCoalescing verifies that a burst of updates for the same
+// resource name collapses into a single reconciliation using the latest
+// desired state, instead of triggering one OnUpdate per update.
+func TestReconcilerCoalescing(t *testing.T) {
+	t.Parallel()
+
+	const name = "res-bursty"
+	release := make(chan struct{})
+	var onUpdateCalls []types.ResourceWithLabels
+	var mu sync.Mutex
+
+	reconciler, err := NewReconciler(ReconcilerConfig{
+		Matcher: func(rwl types.ResourceWithLabels) bool { return true },
+		GetResources: func() types.ResourcesWithLabels {
+			return types.ResourcesWithLabels{makeDynamicResource(name, map[string]string{"v": "0"})}
+		},
+		OnCreate: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+		OnUpdate: func(ctx context.Context, r types.ResourceWithLabels) error {
+			<-release // block the only in-flight attempt until the burst lands
+			mu.Lock()
+			onUpdateCalls = append(onUpdateCalls, r)
+			mu.Unlock()
+			return nil
+		},
+		OnDelete: func(ctx context.Context, r types.ResourceWithLabels) error { return nil },
+		Workers:  1,
+	})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		v := i
+		go func() {
+			defer wg.Done()
+			// Reconcile blocks until its update has had a first
+			// processing attempt, so these calls are fired
+			// concurrently: the worker is stuck in OnUpdate for
+			// the first one to arrive while the rest pile up and
+			// coalesce.
+			require.NoError(t, reconciler.Reconcile(context.Background(), types.ResourcesWithLabels{
+				makeDynamicResource(name, map[string]string{"v": fmt.Sprintf("%d", v)}),
+			}))
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, onUpdateCalls)
+	last := onUpdateCalls[len(onUpdateCalls)-1]
+	require.Equal(t, "5", last.GetAllLabels()["v"])
+}
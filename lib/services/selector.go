@@ -33,6 +33,10 @@ type Selector struct {
 	MatchRDS RDSMatcher
 	// MatchRedshift is a selector that matches Redshift databases.
 	MatchRedshift RedshiftMatcher
+	// MatchAzureSQL is a selector that matches Azure SQL databases.
+	MatchAzureSQL AzureSQLMatcher
+	// MatchGCPSQL is a selector that matches GCP Cloud SQL databases.
+	MatchGCPSQL GCPSQLMatcher
 }
 
 // RDSMatcher is a selector that matches RDS databases.
@@ -41,6 +45,18 @@ type RDSMatcher struct {
 	Regions []string
 	// Tags are RDS resource tags to match.
 	Tags types.Labels
+	// AssumeRoleARN is the ARN of an IAM role to assume, in another AWS
+	// account, before querying and configuring databases it discovers.
+	// Lets a single Teleport database agent discover RDS instances across
+	// many accounts without a set of static credentials per account.
+	AssumeRoleARN string
+	// ExternalID is an optional external ID to include in the AssumeRole
+	// request, for accounts that require one.
+	ExternalID string
+	// LabelsFromTags maps RDS resource tag keys to the Teleport label keys
+	// they should be projected into, eg. {"Team": "team"}. Tags not listed
+	// here aren't turned into labels.
+	LabelsFromTags map[string]string
 }
 
 // RedshiftMatcher is a selector that matches Redshift databases.
@@ -49,6 +65,39 @@ type RedshiftMatcher struct {
 	Regions []string
 	// Tags are Redshift resource tags to match.
 	Tags types.Labels
+	// AssumeRoleARN is the ARN of an IAM role to assume, in another AWS
+	// account, before querying and configuring databases it discovers.
+	AssumeRoleARN string
+	// ExternalID is an optional external ID to include in the AssumeRole
+	// request, for accounts that require one.
+	ExternalID string
+	// LabelsFromTags maps Redshift resource tag keys to the Teleport label
+	// keys they should be projected into, eg. {"Team": "team"}.
+	LabelsFromTags map[string]string
+}
+
+// AzureSQLMatcher is a selector that matches Azure SQL databases (both the
+// single-server and managed instance flavors).
+type AzureSQLMatcher struct {
+	// Subscriptions are Azure subscription IDs to query databases in.
+	Subscriptions []string
+	// ResourceGroups are resource groups to query databases in. An empty
+	// list means all resource groups in the subscription.
+	ResourceGroups []string
+	// Regions are Azure regions to query databases in.
+	Regions []string
+	// Tags are Azure resource tags to match.
+	Tags types.Labels
+}
+
+// GCPSQLMatcher is a selector that matches GCP Cloud SQL databases.
+type GCPSQLMatcher struct {
+	// ProjectIDs are GCP project IDs to query databases in.
+	ProjectIDs []string
+	// Regions are GCP regions to query databases in.
+	Regions []string
+	// Tags are GCP resource labels to match.
+	Tags types.Labels
 }
 
 // String returns the selector string representation.
@@ -59,13 +108,42 @@ func (s Selector) String() string {
 	}
 	if len(s.MatchRDS.Tags) != 0 {
 		parts = append(parts, fmt.Sprintf("MatchRDS(%v)", s.MatchRDS.Tags))
+		if s.MatchRDS.AssumeRoleARN != "" {
+			parts = append(parts, fmt.Sprintf("AssumeRoleARN(%v)", s.MatchRDS.AssumeRoleARN))
+		}
 	}
 	if len(s.MatchRedshift.Tags) != 0 {
 		parts = append(parts, fmt.Sprintf("MatchRedshift(%v)", s.MatchRedshift.Tags))
+		if s.MatchRedshift.AssumeRoleARN != "" {
+			parts = append(parts, fmt.Sprintf("AssumeRoleARN(%v)", s.MatchRedshift.AssumeRoleARN))
+		}
+	}
+	if len(s.MatchAzureSQL.Tags) != 0 {
+		parts = append(parts, fmt.Sprintf("MatchAzureSQL(%v)", s.MatchAzureSQL.Tags))
+	}
+	if len(s.MatchGCPSQL.Tags) != 0 {
+		parts = append(parts, fmt.Sprintf("MatchGCPSQL(%v)", s.MatchGCPSQL.Tags))
 	}
 	return strings.Join(parts, ", ")
 }
 
+// LabelsFromTags projects the tags a cloud resource carries into Teleport
+// labels, according to mapping (resource tag key -> Teleport label key), as
+// configured by RDSMatcher.LabelsFromTags/RedshiftMatcher.LabelsFromTags.
+// Tags not named in mapping are ignored.
+func LabelsFromTags(mapping map[string]string, tags map[string]string) map[string]string {
+	if len(mapping) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(mapping))
+	for tagKey, labelKey := range mapping {
+		if value, ok := tags[tagKey]; ok {
+			labels[labelKey] = value
+		}
+	}
+	return labels
+}
+
 // MatchResourceLabels returns true if any of the provided selectors matches the provided database.
 func MatchResourceLabels(selectors []Selector, resource types.ResourceWithLabels) bool {
 	for _, selector := range selectors {
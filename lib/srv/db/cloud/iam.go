@@ -0,0 +1,366 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cloud configures cloud databases for IAM authentication.
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsarn "github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/srv/db/common"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// PolicyStrategy selects how IAM configures the permission Teleport's own
+// identity needs to authenticate to a discovered database
+// ("rds-db:connect").
+type PolicyStrategy string
+
+const (
+	// PolicyStrategyInline grants access via a single inline policy on
+	// Teleport's IAM role, with one statement per database. Simplest
+	// option, but inline policies are capped at 10KB, which large
+	// deployments with many discovered databases can hit.
+	PolicyStrategyInline PolicyStrategy = "inline"
+	// PolicyStrategyManaged grants access via a dedicated customer-managed
+	// policy attached to Teleport's role, sidestepping the inline policy
+	// size limit (at the cost of the separate 6KB-per-version managed
+	// policy limit, and a bounded number of policy versions).
+	PolicyStrategyManaged PolicyStrategy = "managed"
+	// PolicyStrategyBoundaryAware behaves like PolicyStrategyManaged, but
+	// first checks that Teleport's role has no permissions boundary that
+	// would silently deny the rds-db:connect action, failing loudly
+	// instead of granting an access grant that wouldn't actually work.
+	PolicyStrategyBoundaryAware PolicyStrategy = "boundary-aware"
+)
+
+// checkAndSetDefaults validates the strategy, defaulting to
+// PolicyStrategyInline (the long-standing behavior) when unset.
+func (s *PolicyStrategy) checkAndSetDefaults() error {
+	switch *s {
+	case "":
+		*s = PolicyStrategyInline
+	case PolicyStrategyInline, PolicyStrategyManaged, PolicyStrategyBoundaryAware:
+	default:
+		return trace.BadParameter("unsupported IAM policy strategy %q", *s)
+	}
+	return nil
+}
+
+// policyName is the name used for both the inline policy statement
+// container and the managed policy, so switching strategies in config
+// cleans up after the previous one rather than leaving two grants behind.
+const policyName = "TeleportDatabaseAccess"
+
+// IAMConfig is the database IAM configurator configuration.
+type IAMConfig struct {
+	// Clients provides cloud API clients.
+	Clients common.CloudClients
+	// PolicyStrategy selects how the rds-db:connect grant is made. Defaults
+	// to PolicyStrategyInline.
+	PolicyStrategy PolicyStrategy
+	// Log is the configurator's logger.
+	Log logrus.FieldLogger
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *IAMConfig) CheckAndSetDefaults() error {
+	if c.Clients == nil {
+		return trace.BadParameter("missing parameter Clients")
+	}
+	if err := c.PolicyStrategy.checkAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Log == nil {
+		c.Log = logrus.WithField(trace.Component, "iam")
+	}
+	return nil
+}
+
+// IAM enables IAM authentication on cloud databases, and grants Teleport's
+// own AWS identity the permission it needs to connect to them.
+type IAM struct {
+	cfg IAMConfig
+	mu  sync.Mutex
+}
+
+// NewIAM creates a new database IAM configurator.
+func NewIAM(ctx context.Context, cfg IAMConfig) (*IAM, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &IAM{cfg: cfg}, nil
+}
+
+// Setup enables IAM auth on the database (if it isn't already), then grants
+// Teleport's identity the rds-db:connect permission needed to reach it.
+func (c *IAM) Setup(ctx context.Context, database types.Database) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	meta := database.GetAWS()
+	resourceARN, err := c.enableIAMAuth(ctx, database)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	roleName, err := c.identityRoleName(ctx, meta)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	attacher, err := c.newPolicyAttacher(ctx, meta)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := attacher.attach(ctx, roleName, database.GetName(), resourceARN); err != nil {
+		return trace.Wrap(err, "granting %v access to %v", roleName, database.GetName())
+	}
+	c.cfg.Log.Infof("Granted %v access to %v via %v policy.", roleName, database.GetName(), c.cfg.PolicyStrategy)
+	return nil
+}
+
+// Teardown revokes the rds-db:connect permission Setup granted.
+func (c *IAM) Teardown(ctx context.Context, database types.Database) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	meta := database.GetAWS()
+	roleName, err := c.identityRoleName(ctx, meta)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	attacher, err := c.newPolicyAttacher(ctx, meta)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := attacher.detach(ctx, roleName, database.GetName()); err != nil {
+		return trace.Wrap(err, "revoking %v access to %v", roleName, database.GetName())
+	}
+	return nil
+}
+
+// identityRoleName returns the name of the IAM role that needs the
+// rds-db:connect grant for database. For a database discovered via
+// AssumeRoleARN (cross-account discovery), that's the assumed role itself,
+// in the target account; otherwise it's Teleport's own running identity.
+func (c *IAM) identityRoleName(ctx context.Context, meta types.AWS) (string, error) {
+	if meta.AssumeRoleARN != "" {
+		return roleNameFromARN(meta.AssumeRoleARN)
+	}
+	identityARN, err := c.getIdentityARN(ctx, meta.Region)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return roleNameFromARN(identityARN)
+}
+
+// newPolicyAttacher builds the policyAttacher matching the configured
+// PolicyStrategy, using an IAM client in the database's own account.
+func (c *IAM) newPolicyAttacher(ctx context.Context, meta types.AWS) (policyAttacher, error) {
+	iamClient, err := c.getIAMClient(ctx, meta)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	switch c.cfg.PolicyStrategy {
+	case PolicyStrategyInline:
+		return &inlinePolicyAttacher{iam: iamClient}, nil
+	case PolicyStrategyManaged:
+		return &managedPolicyAttacher{iam: iamClient}, nil
+	case PolicyStrategyBoundaryAware:
+		return &boundaryAwarePolicyAttacher{managedPolicyAttacher{iam: iamClient}}, nil
+	default:
+		return nil, trace.BadParameter("unsupported IAM policy strategy %q", c.cfg.PolicyStrategy)
+	}
+}
+
+// getRDSClient returns an RDS client for meta's region, assuming
+// AssumeRoleARN in meta's own AWS account if set.
+func (c *IAM) getRDSClient(meta types.AWS) (rdsAPI, error) {
+	if meta.AssumeRoleARN != "" {
+		return c.cfg.Clients.GetAWSRDSClientForAccount(meta.Region, meta.AssumeRoleARN, meta.ExternalID)
+	}
+	return c.cfg.Clients.GetAWSRDSClient(meta.Region)
+}
+
+// getIAMClient returns an IAM client for meta's region, assuming
+// AssumeRoleARN in meta's own AWS account if set, so policy changes land on
+// the role Teleport is actually using to connect to that account's
+// databases.
+func (c *IAM) getIAMClient(ctx context.Context, meta types.AWS) (iamAPI, error) {
+	if meta.AssumeRoleARN != "" {
+		return c.cfg.Clients.GetAWSIAMClientForAccount(meta.Region, meta.AssumeRoleARN, meta.ExternalID)
+	}
+	return c.cfg.Clients.GetAWSIAMClient(meta.Region)
+}
+
+// enableIAMAuth turns on IAM database authentication for database (if it
+// isn't already on) and returns the rds-db ARN Teleport's identity needs
+// rds-db:connect on to reach it.
+func (c *IAM) enableIAMAuth(ctx context.Context, database types.Database) (string, error) {
+	meta := database.GetAWS()
+	client, err := c.getRDSClient(meta)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	switch {
+	case meta.RDS.ClusterID != "":
+		return c.enableAuroraIAMAuth(ctx, client, meta)
+	case meta.RDS.InstanceID != "":
+		return c.enableRDSIAMAuth(ctx, client, meta)
+	default:
+		return "", trace.BadParameter("database %v is not an RDS or Aurora database", database.GetName())
+	}
+}
+
+// rdsAPI is the subset of the RDS API the IAM configurator needs.
+type rdsAPI interface {
+	DescribeDBInstancesWithContext(aws.Context, *rds.DescribeDBInstancesInput, ...request.Option) (*rds.DescribeDBInstancesOutput, error)
+	DescribeDBClustersWithContext(aws.Context, *rds.DescribeDBClustersInput, ...request.Option) (*rds.DescribeDBClustersOutput, error)
+	ModifyDBInstanceWithContext(aws.Context, *rds.ModifyDBInstanceInput, ...request.Option) (*rds.ModifyDBInstanceOutput, error)
+	ModifyDBClusterWithContext(aws.Context, *rds.ModifyDBClusterInput, ...request.Option) (*rds.ModifyDBClusterOutput, error)
+}
+
+func (c *IAM) enableRDSIAMAuth(ctx context.Context, client rdsAPI, meta types.AWS) (string, error) {
+	out, err := client.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(meta.RDS.InstanceID),
+	})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if len(out.DBInstances) != 1 {
+		return "", trace.BadParameter("expected 1 DB instance for %v, got %v", meta.RDS.InstanceID, len(out.DBInstances))
+	}
+	instance := out.DBInstances[0]
+
+	if !aws.BoolValue(instance.IAMDatabaseAuthenticationEnabled) {
+		if _, err := client.ModifyDBInstanceWithContext(ctx, &rds.ModifyDBInstanceInput{
+			DBInstanceIdentifier:            instance.DBInstanceIdentifier,
+			EnableIAMDatabaseAuthentication: aws.Bool(true),
+			ApplyImmediately:                aws.Bool(true),
+		}); err != nil {
+			return "", trace.Wrap(err)
+		}
+	}
+	return rdsDBUserARN(aws.StringValue(instance.DBInstanceArn), aws.StringValue(instance.DbiResourceId))
+}
+
+func (c *IAM) enableAuroraIAMAuth(ctx context.Context, client rdsAPI, meta types.AWS) (string, error) {
+	out, err := client.DescribeDBClustersWithContext(ctx, &rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(meta.RDS.ClusterID),
+	})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if len(out.DBClusters) != 1 {
+		return "", trace.BadParameter("expected 1 DB cluster for %v, got %v", meta.RDS.ClusterID, len(out.DBClusters))
+	}
+	cluster := out.DBClusters[0]
+
+	if !aws.BoolValue(cluster.IAMDatabaseAuthenticationEnabled) {
+		if _, err := client.ModifyDBClusterWithContext(ctx, &rds.ModifyDBClusterInput{
+			DBClusterIdentifier:             cluster.DBClusterIdentifier,
+			EnableIAMDatabaseAuthentication: aws.Bool(true),
+			ApplyImmediately:                aws.Bool(true),
+		}); err != nil {
+			return "", trace.Wrap(err)
+		}
+	}
+	return rdsDBUserARN(aws.StringValue(cluster.DBClusterArn), aws.StringValue(cluster.DbClusterResourceId))
+}
+
+// rdsDBUserARN builds the "rds-db:connect"-scoped ARN for an RDS/Aurora
+// resource, given its own ARN (for account/region/partition) and its
+// internal resource ID.
+func rdsDBUserARN(resourceARN, resourceID string) (string, error) {
+	parsed, err := awsarn.Parse(resourceARN)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if resourceID == "" {
+		return "", trace.BadParameter("missing resource ID for %v", resourceARN)
+	}
+	return awsarn.ARN{
+		Partition: parsed.Partition,
+		Service:   "rds-db",
+		Region:    parsed.Region,
+		AccountID: parsed.AccountID,
+		Resource:  fmt.Sprintf("dbuser:%v/*", resourceID),
+	}.String(), nil
+}
+
+// getIdentityARN returns the ARN of the AWS identity Teleport is running as.
+func (c *IAM) getIdentityARN(ctx context.Context, region string) (string, error) {
+	stsClient, err := c.cfg.Clients.GetAWSSTSClient(region)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	out, err := stsClient.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return aws.StringValue(out.Arn), nil
+}
+
+// roleNameFromARN extracts the role name from an STS-assumed-role or IAM
+// role ARN, eg. "arn:aws:iam::1234567890:role/test-role" -> "test-role".
+func roleNameFromARN(identityARN string) (string, error) {
+	parsed, err := awsarn.Parse(identityARN)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	// Resource is either "role/<name>" or "assumed-role/<name>/<session>".
+	parts := splitResource(parsed.Resource)
+	switch parts[0] {
+	case "role":
+		if len(parts) != 2 {
+			return "", trace.BadParameter("malformed role ARN resource %q", parsed.Resource)
+		}
+		return parts[1], nil
+	case "assumed-role":
+		if len(parts) != 3 {
+			return "", trace.BadParameter("malformed assumed-role ARN resource %q", parsed.Resource)
+		}
+		return parts[1], nil
+	default:
+		return "", trace.BadParameter("identity %v is not an IAM role", identityARN)
+	}
+}
+
+func splitResource(resource string) []string {
+	var parts []string
+	start := 0
+	for i, c := range resource {
+		if c == '/' {
+			parts = append(parts, resource[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, resource[start:])
+}
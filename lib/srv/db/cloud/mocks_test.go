@@ -0,0 +1,343 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/gravitational/trace"
+)
+
+// RDSMock mocks the subset of the RDS API the IAM configurator uses.
+type RDSMock struct {
+	dbInstances []*rds.DBInstance
+	dbClusters  []*rds.DBCluster
+}
+
+func (m *RDSMock) DescribeDBInstancesWithContext(_ aws.Context, in *rds.DescribeDBInstancesInput, _ ...request.Option) (*rds.DescribeDBInstancesOutput, error) {
+	for _, instance := range m.dbInstances {
+		if aws.StringValue(instance.DBInstanceIdentifier) == aws.StringValue(in.DBInstanceIdentifier) {
+			return &rds.DescribeDBInstancesOutput{DBInstances: []*rds.DBInstance{instance}}, nil
+		}
+	}
+	return nil, trace.NotFound("db instance %v not found", aws.StringValue(in.DBInstanceIdentifier))
+}
+
+func (m *RDSMock) DescribeDBClustersWithContext(_ aws.Context, in *rds.DescribeDBClustersInput, _ ...request.Option) (*rds.DescribeDBClustersOutput, error) {
+	for _, cluster := range m.dbClusters {
+		if aws.StringValue(cluster.DBClusterIdentifier) == aws.StringValue(in.DBClusterIdentifier) {
+			return &rds.DescribeDBClustersOutput{DBClusters: []*rds.DBCluster{cluster}}, nil
+		}
+	}
+	return nil, trace.NotFound("db cluster %v not found", aws.StringValue(in.DBClusterIdentifier))
+}
+
+func (m *RDSMock) ModifyDBInstanceWithContext(_ aws.Context, in *rds.ModifyDBInstanceInput, _ ...request.Option) (*rds.ModifyDBInstanceOutput, error) {
+	for _, instance := range m.dbInstances {
+		if aws.StringValue(instance.DBInstanceIdentifier) == aws.StringValue(in.DBInstanceIdentifier) {
+			instance.IAMDatabaseAuthenticationEnabled = in.EnableIAMDatabaseAuthentication
+			return &rds.ModifyDBInstanceOutput{DBInstance: instance}, nil
+		}
+	}
+	return nil, trace.NotFound("db instance %v not found", aws.StringValue(in.DBInstanceIdentifier))
+}
+
+func (m *RDSMock) ModifyDBClusterWithContext(_ aws.Context, in *rds.ModifyDBClusterInput, _ ...request.Option) (*rds.ModifyDBClusterOutput, error) {
+	for _, cluster := range m.dbClusters {
+		if aws.StringValue(cluster.DBClusterIdentifier) == aws.StringValue(in.DBClusterIdentifier) {
+			cluster.IAMDatabaseAuthenticationEnabled = in.EnableIAMDatabaseAuthentication
+			return &rds.ModifyDBClusterOutput{DBCluster: cluster}, nil
+		}
+	}
+	return nil, trace.NotFound("db cluster %v not found", aws.StringValue(in.DBClusterIdentifier))
+}
+
+// STSMock mocks the subset of the STS API the IAM configurator uses,
+// always returning the same identity ARN.
+type STSMock struct {
+	arn string
+}
+
+func (m *STSMock) GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String(m.arn)}, nil
+}
+
+// IAMMock mocks the subset of the IAM API the policy attachers use, storing
+// inline role policies in memory. attachedRolePolicies mirrors, per role,
+// the Sids (database names) currently granted access, for test assertions.
+type IAMMock struct {
+	mu                   sync.Mutex
+	rolePolicies         map[string]string
+	attachedRolePolicies map[string][]string
+	roles                map[string]*iam.Role
+	policies             map[string]*mockPolicy
+	attachedPolicyArns   map[string][]string
+}
+
+func (m *IAMMock) GetRolePolicyWithContext(_ aws.Context, in *iam.GetRolePolicyInput, _ ...request.Option) (*iam.GetRolePolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.rolePolicies[aws.StringValue(in.RoleName)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such role policy", nil)
+	}
+	return &iam.GetRolePolicyOutput{
+		RoleName:       in.RoleName,
+		PolicyName:     in.PolicyName,
+		PolicyDocument: aws.String(url.QueryEscape(doc)),
+	}, nil
+}
+
+func (m *IAMMock) PutRolePolicyWithContext(_ aws.Context, in *iam.PutRolePolicyInput, _ ...request.Option) (*iam.PutRolePolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roleName := aws.StringValue(in.RoleName)
+	m.setRolePolicy(roleName, aws.StringValue(in.PolicyDocument))
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (m *IAMMock) DeleteRolePolicyWithContext(_ aws.Context, in *iam.DeleteRolePolicyInput, _ ...request.Option) (*iam.DeleteRolePolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roleName := aws.StringValue(in.RoleName)
+	delete(m.rolePolicies, roleName)
+	m.setAttached(roleName, nil)
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+// setRolePolicy stores doc verbatim (as AWS does on Put) and refreshes
+// attachedRolePolicies from its Sids, for test assertions.
+func (m *IAMMock) setRolePolicy(roleName, doc string) {
+	if m.rolePolicies == nil {
+		m.rolePolicies = make(map[string]string)
+	}
+	m.rolePolicies[roleName] = doc
+
+	var parsed policyDocument
+	if err := json.Unmarshal([]byte(doc), &parsed); err != nil {
+		return
+	}
+	sids := make([]string, len(parsed.Statement))
+	for i, statement := range parsed.Statement {
+		sids[i] = statement.Sid
+	}
+	sort.Strings(sids)
+	m.setAttached(roleName, sids)
+}
+
+func (m *IAMMock) setAttached(roleName string, sids []string) {
+	if m.attachedRolePolicies == nil {
+		m.attachedRolePolicies = make(map[string][]string)
+	}
+	if sids == nil {
+		sids = []string{}
+	}
+	m.attachedRolePolicies[roleName] = sids
+}
+
+// mockPolicyVersion is one version of a mocked customer-managed policy.
+type mockPolicyVersion struct {
+	id       string
+	document string
+}
+
+// mockPolicy is a mocked customer-managed policy and its versions, keyed by
+// ARN in IAMMock.policies.
+type mockPolicy struct {
+	defaultVersion string
+	versions       []mockPolicyVersion
+	nextVersion    int
+}
+
+// setBoundary configures a permissions boundary policy on roleName, for
+// boundaryAwarePolicyAttacher tests. document is the boundary's raw (not
+// url-escaped) policy document.
+func (m *IAMMock) setBoundary(roleName, boundaryARN, document string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensureRole(roleName).PermissionsBoundary = &iam.AttachedPermissionsBoundary{
+		PermissionsBoundaryArn: aws.String(boundaryARN),
+	}
+	m.policies[boundaryARN] = &mockPolicy{
+		defaultVersion: "v1",
+		versions:       []mockPolicyVersion{{id: "v1", document: document}},
+		nextVersion:    2,
+	}
+}
+
+// ensureRole returns roleName's mocked Role, creating one (with a
+// deterministic ARN) on first use. Callers must hold m.mu.
+func (m *IAMMock) ensureRole(roleName string) *iam.Role {
+	if m.roles == nil {
+		m.roles = make(map[string]*iam.Role)
+	}
+	if m.roles[roleName] == nil {
+		m.roles[roleName] = &iam.Role{
+			RoleName: aws.String(roleName),
+			Arn:      aws.String(fmt.Sprintf("arn:aws:iam::1234567890:role/%v", roleName)),
+		}
+	}
+	return m.roles[roleName]
+}
+
+func (m *IAMMock) GetRoleWithContext(_ aws.Context, in *iam.GetRoleInput, _ ...request.Option) (*iam.GetRoleOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &iam.GetRoleOutput{Role: m.ensureRole(aws.StringValue(in.RoleName))}, nil
+}
+
+func (m *IAMMock) GetPolicyWithContext(_ aws.Context, in *iam.GetPolicyInput, _ ...request.Option) (*iam.GetPolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policy, ok := m.policies[aws.StringValue(in.PolicyArn)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy", nil)
+	}
+	return &iam.GetPolicyOutput{Policy: &iam.Policy{
+		Arn:              in.PolicyArn,
+		DefaultVersionId: aws.String(policy.defaultVersion),
+	}}, nil
+}
+
+func (m *IAMMock) GetPolicyVersionWithContext(_ aws.Context, in *iam.GetPolicyVersionInput, _ ...request.Option) (*iam.GetPolicyVersionOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policy, ok := m.policies[aws.StringValue(in.PolicyArn)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy", nil)
+	}
+	for _, version := range policy.versions {
+		if version.id == aws.StringValue(in.VersionId) {
+			return &iam.GetPolicyVersionOutput{PolicyVersion: &iam.PolicyVersion{
+				VersionId: aws.String(version.id),
+				Document:  aws.String(url.QueryEscape(version.document)),
+			}}, nil
+		}
+	}
+	return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy version", nil)
+}
+
+func (m *IAMMock) CreatePolicyWithContext(_ aws.Context, in *iam.CreatePolicyInput, _ ...request.Option) (*iam.CreatePolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.policies == nil {
+		m.policies = make(map[string]*mockPolicy)
+	}
+	arn := fmt.Sprintf("arn:aws:iam::1234567890:policy/%v", aws.StringValue(in.PolicyName))
+	m.policies[arn] = &mockPolicy{
+		defaultVersion: "v1",
+		versions:       []mockPolicyVersion{{id: "v1", document: aws.StringValue(in.PolicyDocument)}},
+		nextVersion:    2,
+	}
+	return &iam.CreatePolicyOutput{Policy: &iam.Policy{Arn: aws.String(arn)}}, nil
+}
+
+func (m *IAMMock) CreatePolicyVersionWithContext(_ aws.Context, in *iam.CreatePolicyVersionInput, _ ...request.Option) (*iam.CreatePolicyVersionOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policy, ok := m.policies[aws.StringValue(in.PolicyArn)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy", nil)
+	}
+	if len(policy.versions) >= 5 {
+		return nil, awserr.New(iam.ErrCodeLimitExceededException, "too many policy versions", nil)
+	}
+	id := fmt.Sprintf("v%v", policy.nextVersion)
+	policy.nextVersion++
+	policy.versions = append(policy.versions, mockPolicyVersion{id: id, document: aws.StringValue(in.PolicyDocument)})
+	if aws.BoolValue(in.SetAsDefault) {
+		policy.defaultVersion = id
+	}
+	return &iam.CreatePolicyVersionOutput{PolicyVersion: &iam.PolicyVersion{VersionId: aws.String(id)}}, nil
+}
+
+func (m *IAMMock) DeletePolicyVersionWithContext(_ aws.Context, in *iam.DeletePolicyVersionInput, _ ...request.Option) (*iam.DeletePolicyVersionOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policy, ok := m.policies[aws.StringValue(in.PolicyArn)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy", nil)
+	}
+	for i, version := range policy.versions {
+		if version.id == aws.StringValue(in.VersionId) {
+			policy.versions = append(policy.versions[:i], policy.versions[i+1:]...)
+			return &iam.DeletePolicyVersionOutput{}, nil
+		}
+	}
+	return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy version", nil)
+}
+
+func (m *IAMMock) ListPolicyVersionsWithContext(_ aws.Context, in *iam.ListPolicyVersionsInput, _ ...request.Option) (*iam.ListPolicyVersionsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policy, ok := m.policies[aws.StringValue(in.PolicyArn)]
+	if !ok {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such policy", nil)
+	}
+	out := make([]*iam.PolicyVersion, len(policy.versions))
+	for i, version := range policy.versions {
+		out[i] = &iam.PolicyVersion{
+			VersionId:        aws.String(version.id),
+			IsDefaultVersion: aws.Bool(version.id == policy.defaultVersion),
+			CreateDate:       aws.Time(time.Unix(int64(i), 0)),
+		}
+	}
+	return &iam.ListPolicyVersionsOutput{Versions: out}, nil
+}
+
+func (m *IAMMock) AttachRolePolicyWithContext(_ aws.Context, in *iam.AttachRolePolicyInput, _ ...request.Option) (*iam.AttachRolePolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roleName := aws.StringValue(in.RoleName)
+	if m.attachedPolicyArns == nil {
+		m.attachedPolicyArns = make(map[string][]string)
+	}
+	arn := aws.StringValue(in.PolicyArn)
+	for _, existing := range m.attachedPolicyArns[roleName] {
+		if existing == arn {
+			return &iam.AttachRolePolicyOutput{}, nil
+		}
+	}
+	m.attachedPolicyArns[roleName] = append(m.attachedPolicyArns[roleName], arn)
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (m *IAMMock) DetachRolePolicyWithContext(_ aws.Context, in *iam.DetachRolePolicyInput, _ ...request.Option) (*iam.DetachRolePolicyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roleName := aws.StringValue(in.RoleName)
+	arn := aws.StringValue(in.PolicyArn)
+	for i, existing := range m.attachedPolicyArns[roleName] {
+		if existing == arn {
+			m.attachedPolicyArns[roleName] = append(m.attachedPolicyArns[roleName][:i], m.attachedPolicyArns[roleName][i+1:]...)
+			break
+		}
+	}
+	return &iam.DetachRolePolicyOutput{}, nil
+}
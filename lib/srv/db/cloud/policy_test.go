@@ -0,0 +1,118 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/defaults"
+	"github.com/gravitational/teleport/lib/srv/db/common"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagedPolicyAttacher(t *testing.T) {
+	ctx := context.Background()
+
+	rdsInstance := &rds.DBInstance{
+		DBInstanceArn:        aws.String("arn:aws:rds:us-west-1:1234567890:db:postgres-rds"),
+		DBInstanceIdentifier: aws.String("postgres-rds"),
+		DbiResourceId:        aws.String("db-xyz"),
+	}
+	database, err := types.NewDatabaseV3(types.Metadata{
+		Name: "postgres-rds",
+	}, types.DatabaseSpecV3{
+		Protocol: defaults.ProtocolPostgres,
+		URI:      "localhost",
+		AWS:      types.AWS{RDS: types.RDS{InstanceID: "postgres-rds"}},
+	})
+	require.NoError(t, err)
+
+	iamClient := &IAMMock{}
+	configurator, err := NewIAM(ctx, IAMConfig{
+		Clients: &common.TestCloudClients{
+			RDS: &RDSMock{dbInstances: []*rds.DBInstance{rdsInstance}},
+			STS: &STSMock{arn: "arn:aws:iam::1234567890:role/test-role"},
+			IAM: iamClient,
+		},
+		PolicyStrategy: PolicyStrategyManaged,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, configurator.Setup(ctx, database))
+	require.True(t, aws.BoolValue(rdsInstance.IAMDatabaseAuthenticationEnabled))
+	policyARN := "arn:aws:iam::1234567890:policy/" + policyName
+	require.Contains(t, iamClient.attachedPolicyArns["test-role"], policyARN)
+	require.Contains(t, iamClient.policies[policyARN].versions[len(iamClient.policies[policyARN].versions)-1].document, "postgres-rds")
+
+	require.NoError(t, configurator.Teardown(ctx, database))
+	require.NotContains(t, iamClient.policies[policyARN].versions[len(iamClient.policies[policyARN].versions)-1].document, "postgres-rds")
+	require.NotContains(t, iamClient.attachedPolicyArns["test-role"], policyARN, "policy should be detached once no database uses it")
+}
+
+func TestBoundaryAwarePolicyAttacher(t *testing.T) {
+	ctx := context.Background()
+
+	rdsInstance := &rds.DBInstance{
+		DBInstanceArn:        aws.String("arn:aws:rds:us-west-1:1234567890:db:postgres-rds"),
+		DBInstanceIdentifier: aws.String("postgres-rds"),
+		DbiResourceId:        aws.String("db-xyz"),
+	}
+	database, err := types.NewDatabaseV3(types.Metadata{
+		Name: "postgres-rds",
+	}, types.DatabaseSpecV3{
+		Protocol: defaults.ProtocolPostgres,
+		URI:      "localhost",
+		AWS:      types.AWS{RDS: types.RDS{InstanceID: "postgres-rds"}},
+	})
+	require.NoError(t, err)
+
+	newConfigurator := func(t *testing.T, iamClient *IAMMock) *IAM {
+		configurator, err := NewIAM(ctx, IAMConfig{
+			Clients: &common.TestCloudClients{
+				RDS: &RDSMock{dbInstances: []*rds.DBInstance{rdsInstance}},
+				STS: &STSMock{arn: "arn:aws:iam::1234567890:role/test-role"},
+				IAM: iamClient,
+			},
+			PolicyStrategy: PolicyStrategyBoundaryAware,
+		})
+		require.NoError(t, err)
+		return configurator
+	}
+
+	t.Run("boundary denies rds-db:connect", func(t *testing.T) {
+		iamClient := &IAMMock{}
+		iamClient.setBoundary("test-role", "arn:aws:iam::1234567890:policy/restrictive-boundary",
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject"],"Resource":["*"]}]}`)
+
+		err := newConfigurator(t, iamClient).Setup(ctx, database)
+		require.True(t, trace.IsAccessDenied(err), "expected access denied, got %v", err)
+	})
+
+	t.Run("boundary allows rds-db:connect", func(t *testing.T) {
+		iamClient := &IAMMock{}
+		iamClient.setBoundary("test-role", "arn:aws:iam::1234567890:policy/permissive-boundary",
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["rds-db:connect"],"Resource":["*"]}]}`)
+
+		require.NoError(t, newConfigurator(t, iamClient).Setup(ctx, database))
+	})
+}
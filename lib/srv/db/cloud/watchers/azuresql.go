@@ -0,0 +1,146 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/sql/mgmt/2014-04-01/sql"
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/gravitational/trace"
+)
+
+// azureSQLClient is the subset of the Azure SQL management API the fetcher
+// needs, scoped to a single subscription.
+type azureSQLClient interface {
+	// ListServers returns all Azure SQL logical servers in the subscription.
+	ListServers(ctx context.Context) ([]sql.Server, error)
+}
+
+// azureSQLFetcherConfig is the Azure SQL fetcher configuration.
+type azureSQLFetcherConfig struct {
+	// Labels are Azure resource tags to match.
+	Labels types.Labels
+	// Subscriptions are Azure subscription IDs to query databases in.
+	Subscriptions []string
+	// Regions are Azure regions to query databases in.
+	Regions []string
+	// NewClient creates an azureSQLClient for a given subscription ID.
+	NewClient func(subscriptionID string) (azureSQLClient, error)
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *azureSQLFetcherConfig) CheckAndSetDefaults() error {
+	if len(c.Subscriptions) == 0 {
+		return trace.BadParameter("missing parameter Subscriptions")
+	}
+	if c.NewClient == nil {
+		return trace.BadParameter("missing parameter NewClient")
+	}
+	return nil
+}
+
+// azureSQLFetcher fetches Azure SQL (single-server) databases matching the
+// selector, across all configured subscriptions.
+type azureSQLFetcher struct {
+	cfg azureSQLFetcherConfig
+}
+
+func newAzureSQLFetcher(config azureSQLFetcherConfig) (Fetcher, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &azureSQLFetcher{cfg: config}, nil
+}
+
+// Get returns Azure SQL databases matching the fetcher's selector.
+func (f *azureSQLFetcher) Get(ctx context.Context) (types.Databases, error) {
+	var databases types.Databases
+	for _, subscriptionID := range f.cfg.Subscriptions {
+		client, err := f.cfg.NewClient(subscriptionID)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		servers, err := client.ListServers(ctx)
+		if err != nil {
+			return nil, trace.Wrap(err, "listing Azure SQL servers in subscription %v", subscriptionID)
+		}
+		for _, server := range servers {
+			if !f.matches(server) {
+				continue
+			}
+			database, err := services.NewDatabaseFromAzureSQLServer(server)
+			if err != nil {
+				return nil, trace.Wrap(err, "converting Azure SQL server %q", stringVal(server.Name))
+			}
+			databases = append(databases, database)
+		}
+	}
+	return databases, nil
+}
+
+// matches returns true if server's region and tags satisfy the fetcher's
+// selector.
+func (f *azureSQLFetcher) matches(server sql.Server) bool {
+	if len(f.cfg.Regions) > 0 && !containsFold(f.cfg.Regions, stringVal(server.Location)) {
+		return false
+	}
+	match, _, err := services.MatchLabels(f.cfg.Labels, azureTagsToLabels(server.Tags))
+	if err != nil {
+		return false
+	}
+	return match
+}
+
+// String returns the fetcher's string representation.
+func (f *azureSQLFetcher) String() string {
+	return fmt.Sprintf("azureSQLFetcher(Subscriptions=%v, Regions=%v, Labels=%v)",
+		f.cfg.Subscriptions, f.cfg.Regions, f.cfg.Labels)
+}
+
+// azureTagsToLabels converts Azure's pointer-valued resource tags to the
+// plain map[string]string that services.MatchLabels expects.
+func azureTagsToLabels(tags map[string]*string) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for k, v := range tags {
+		labels[k] = stringVal(v)
+	}
+	return labels
+}
+
+// stringVal dereferences a string pointer, returning "" for nil - the Azure
+// SDK returns most string fields as pointers to distinguish "unset" from "".
+func stringVal(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// containsFold returns true if vals contains s, case-insensitively.
+func containsFold(vals []string, s string) bool {
+	for _, v := range vals {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
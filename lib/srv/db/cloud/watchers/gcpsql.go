@@ -0,0 +1,113 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/gravitational/trace"
+	"google.golang.org/api/sqladmin/v1beta4"
+)
+
+// gcpSQLClient is the subset of the Cloud SQL Admin API the fetcher needs,
+// scoped to a single GCP project.
+type gcpSQLClient interface {
+	// ListInstances returns all Cloud SQL instances in the project.
+	ListInstances(ctx context.Context, projectID string) ([]*sqladmin.DatabaseInstance, error)
+}
+
+// gcpSQLFetcherConfig is the GCP Cloud SQL fetcher configuration.
+type gcpSQLFetcherConfig struct {
+	// Labels are GCP resource labels to match.
+	Labels types.Labels
+	// ProjectIDs are GCP project IDs to query databases in.
+	ProjectIDs []string
+	// Regions are GCP regions to query databases in.
+	Regions []string
+	// Client is the Cloud SQL Admin API client shared across projects.
+	Client gcpSQLClient
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *gcpSQLFetcherConfig) CheckAndSetDefaults() error {
+	if len(c.ProjectIDs) == 0 {
+		return trace.BadParameter("missing parameter ProjectIDs")
+	}
+	if c.Client == nil {
+		return trace.BadParameter("missing parameter Client")
+	}
+	return nil
+}
+
+// gcpSQLFetcher fetches GCP Cloud SQL databases matching the selector,
+// across all configured projects.
+type gcpSQLFetcher struct {
+	cfg gcpSQLFetcherConfig
+}
+
+func newGCPSQLFetcher(config gcpSQLFetcherConfig) (Fetcher, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &gcpSQLFetcher{cfg: config}, nil
+}
+
+// Get returns GCP Cloud SQL databases matching the fetcher's selector.
+func (f *gcpSQLFetcher) Get(ctx context.Context) (types.Databases, error) {
+	var databases types.Databases
+	for _, projectID := range f.cfg.ProjectIDs {
+		instances, err := f.cfg.Client.ListInstances(ctx, projectID)
+		if err != nil {
+			return nil, trace.Wrap(err, "listing Cloud SQL instances in project %v", projectID)
+		}
+		for _, instance := range instances {
+			if !f.matches(instance) {
+				continue
+			}
+			database, err := services.NewDatabaseFromGCPSQLInstance(instance, projectID)
+			if err != nil {
+				return nil, trace.Wrap(err, "converting Cloud SQL instance %q", instance.Name)
+			}
+			databases = append(databases, database)
+		}
+	}
+	return databases, nil
+}
+
+// matches returns true if instance's region and labels satisfy the
+// fetcher's selector.
+func (f *gcpSQLFetcher) matches(instance *sqladmin.DatabaseInstance) bool {
+	if len(f.cfg.Regions) > 0 && !containsFold(f.cfg.Regions, instance.Region) {
+		return false
+	}
+	labels := instance.Settings.UserLabels
+	match, _, err := services.MatchLabels(f.cfg.Labels, labels)
+	if err != nil {
+		return false
+	}
+	return match
+}
+
+// String returns the fetcher's string representation.
+func (f *gcpSQLFetcher) String() string {
+	return fmt.Sprintf("gcpSQLFetcher(ProjectIDs=%v, Regions=%v, Labels=%v)",
+		f.cfg.ProjectIDs, f.cfg.Regions, f.cfg.Labels)
+}
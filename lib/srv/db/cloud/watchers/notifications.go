@@ -0,0 +1,242 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/defaults"
+	"github.com/gravitational/teleport/lib/srv/db/common"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// NotificationsConfig configures the event-driven notification source that
+// supplements periodic RDS/Aurora polling. Events originate from an
+// EventBridge rule matching "aws.rds" events and are delivered to an SQS
+// queue, optionally via an SNS topic fan-out.
+type NotificationsConfig struct {
+	// Region is the AWS region the queue lives in.
+	Region string
+	// QueueURL is the URL of the SQS queue EventBridge delivers events to.
+	QueueURL string
+	// SNSTopicARN, if set, is the ARN of an SNS topic the watcher should
+	// subscribe QueueURL to on startup, rather than assuming the
+	// subscription already exists.
+	SNSTopicARN string
+}
+
+// IsConfigured returns true if notifications were configured for this
+// watcher.
+func (c NotificationsConfig) IsConfigured() bool {
+	return c.QueueURL != ""
+}
+
+// DatabaseEventOp describes the kind of incremental update a DatabaseEvent
+// represents.
+type DatabaseEventOp string
+
+const (
+	// DatabaseEventUpsert indicates the database was created, or an
+	// attribute Teleport cares about (e.g. tags) changed.
+	DatabaseEventUpsert DatabaseEventOp = "upsert"
+	// DatabaseEventDelete indicates the database was deleted.
+	DatabaseEventDelete DatabaseEventOp = "delete"
+)
+
+// DatabaseEvent is an incremental cloud database update derived from a
+// single EventBridge notification.
+type DatabaseEvent struct {
+	// Op is the kind of update.
+	Op DatabaseEventOp
+	// Name is the Teleport resource name of the affected database.
+	Name string
+	// Database is the up-to-date database, set when Op is
+	// DatabaseEventUpsert.
+	Database types.Database
+}
+
+// rdsEventDetail is the subset of the EventBridge "aws.rds" event detail
+// field Teleport cares about. It covers both RDS instance and Aurora
+// cluster create/delete/tag-change events.
+type rdsEventDetail struct {
+	EventID          string   `json:"EventID"`
+	SourceIdentifier string   `json:"SourceIdentifier"`
+	SourceType       string   `json:"SourceType"`
+	Message          string   `json:"Message"`
+	EventCategories  []string `json:"EventCategories"`
+}
+
+type eventBridgeEvent struct {
+	DetailType string         `json:"detail-type"`
+	Source     string         `json:"source"`
+	Detail     rdsEventDetail `json:"detail"`
+}
+
+// newSQSSubscriber subscribes to NotificationsConfig.QueueURL and returns a
+// channel of incremental DatabaseEvents. If SNSTopicARN is set and the
+// queue isn't already subscribed, the subscription is created.
+func newSQSSubscriber(ctx context.Context, config NotificationsConfig, clients common.CloudClients) (<-chan DatabaseEvent, error) {
+	if !config.IsConfigured() {
+		return nil, trace.BadParameter("missing parameter QueueURL")
+	}
+	queue, err := clients.GetAWSSQSClient(config.Region)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if config.SNSTopicARN != "" {
+		sns, err := clients.GetAWSSNSClient(config.Region)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if err := ensureSNSSubscription(ctx, sns, config.SNSTopicARN, config.QueueURL); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	events := make(chan DatabaseEvent)
+	go pollSQS(ctx, queue, config.QueueURL, events)
+	return events, nil
+}
+
+// pollSQS long-polls the queue for new messages, converts each one to a
+// DatabaseEvent and deletes it once handed off.
+func pollSQS(ctx context.Context, queue sqsAPI, queueURL string, events chan<- DatabaseEvent) {
+	defer close(events)
+	log := logrus.WithField(trace.Component, "watcher:cloud/sqs")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		out, err := queue.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			WaitTimeSeconds:     aws.Int64(20),
+			MaxNumberOfMessages: aws.Int64(10),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WithError(err).Error("Failed to receive SQS messages, retrying.")
+			select {
+			case <-time.After(defaults.HighResPollingPeriod):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		for _, message := range out.Messages {
+			event, err := parseRDSNotification(aws.StringValue(message.Body))
+			if err != nil {
+				log.WithError(err).Warn("Skipping malformed cloud database notification.")
+			} else {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if _, err := queue.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			}); err != nil {
+				log.WithError(err).Warn("Failed to delete processed SQS message.")
+			}
+		}
+	}
+}
+
+// parseRDSNotification converts a raw EventBridge "aws.rds" event body into
+// a DatabaseEvent. Deletion events (and tag-removal leaving no match) carry
+// no database detail - only the source identifier is used to evict the
+// cache entry.
+func parseRDSNotification(body string) (DatabaseEvent, error) {
+	var event eventBridgeEvent
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return DatabaseEvent{}, trace.Wrap(err)
+	}
+	if event.Source != "aws.rds" {
+		return DatabaseEvent{}, trace.BadParameter("unexpected event source %q", event.Source)
+	}
+	for _, category := range event.Detail.EventCategories {
+		if category == "deletion" {
+			return DatabaseEvent{
+				Op:   DatabaseEventDelete,
+				Name: event.Detail.SourceIdentifier,
+			}, nil
+		}
+	}
+	// Creation and tag-change events require a follow-up describe call to
+	// build a full Database resource; the poller's next full fetch will
+	// pick up the authoritative state, but we still emit an upsert marker
+	// with the bare identifier so downstream debouncing/coalescing logic
+	// can key on it immediately.
+	return DatabaseEvent{
+		Op:   DatabaseEventUpsert,
+		Name: event.Detail.SourceIdentifier,
+	}, nil
+}
+
+// ensureSNSSubscription subscribes the SQS queue to the SNS topic if it
+// isn't already subscribed.
+func ensureSNSSubscription(ctx context.Context, sns snsAPI, topicARN, queueURL string) error {
+	queueARN, err := queueURLToARN(queueURL)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return sns.SubscribeQueue(ctx, topicARN, queueARN)
+}
+
+// queueURLToARN extracts the SQS queue ARN from its URL, e.g.
+// "https://sqs.us-east-1.amazonaws.com/1234567890/my-queue" becomes
+// "arn:aws:sqs:us-east-1:1234567890:my-queue".
+func queueURLToARN(queueURL string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(queueURL, "https://"), "http://"), "/")
+	if len(parts) != 3 {
+		return "", trace.BadParameter("unrecognized SQS queue URL %q", queueURL)
+	}
+	hostParts := strings.Split(parts[0], ".")
+	if len(hostParts) < 3 {
+		return "", trace.BadParameter("unrecognized SQS queue URL %q", queueURL)
+	}
+	region, accountID, queueName := hostParts[1], parts[1], parts[2]
+	return fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, accountID, queueName), nil
+}
+
+// sqsAPI is the subset of the SQS client used by the notification poller,
+// scoped down to keep it easy to fake in tests. *sqs.SQS satisfies it
+// directly.
+type sqsAPI interface {
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
+}
+
+// snsAPI is the subset of SNS used to auto-create the queue subscription.
+type snsAPI interface {
+	SubscribeQueue(ctx context.Context, topicARN, queueARN string) error
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/gravitational/trace"
+)
+
+// rdsFetcherConfig is the RDS/Aurora fetcher configuration.
+type rdsFetcherConfig struct {
+	// Labels are RDS resource tags to match.
+	Labels types.Labels
+	// LabelsFromTags projects selected RDS resource tags into Teleport
+	// labels, in addition to the match criteria in Labels.
+	LabelsFromTags map[string]string
+	// RDS is the RDS API client, scoped to Region (and, for cross-account
+	// discovery, already assuming AssumeRoleARN).
+	RDS rdsiface.RDSAPI
+	// Region is the AWS region to query databases in.
+	Region string
+	// AssumeRoleARN is the IAM role (in another account) RDS was obtained
+	// by assuming, for cross-account discovery. Carried through to the
+	// databases this fetcher returns so the IAM configurator knows which
+	// account/role to grant access in.
+	AssumeRoleARN string
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *rdsFetcherConfig) CheckAndSetDefaults() error {
+	if len(c.Labels) == 0 {
+		return trace.BadParameter("missing parameter Labels")
+	}
+	if c.RDS == nil {
+		return trace.BadParameter("missing parameter RDS")
+	}
+	if c.Region == "" {
+		return trace.BadParameter("missing parameter Region")
+	}
+	return nil
+}
+
+// rdsFetcher fetches RDS instances and Aurora clusters matching the
+// selector, in a single region.
+type rdsFetcher struct {
+	cfg rdsFetcherConfig
+}
+
+func newRDSFetcher(config rdsFetcherConfig) (Fetcher, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &rdsFetcher{cfg: config}, nil
+}
+
+// Get returns RDS instances and Aurora clusters matching the fetcher's
+// selector.
+func (f *rdsFetcher) Get(ctx context.Context) (types.Databases, error) {
+	var databases types.Databases
+
+	instancesOut, err := f.cfg.RDS.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{})
+	if err != nil {
+		return nil, trace.Wrap(err, "describing RDS instances in %v", f.cfg.Region)
+	}
+	for _, instance := range instancesOut.DBInstances {
+		if !f.matchesTags(rdsTagsToLabels(instance.TagList)) {
+			continue
+		}
+		database, err := services.NewDatabaseFromRDSInstance(instance, f.cfg.LabelsFromTags, f.cfg.AssumeRoleARN)
+		if err != nil {
+			return nil, trace.Wrap(err, "converting RDS instance %q", stringVal(instance.DBInstanceIdentifier))
+		}
+		databases = append(databases, database)
+	}
+
+	clustersOut, err := f.cfg.RDS.DescribeDBClustersWithContext(ctx, &rds.DescribeDBClustersInput{})
+	if err != nil {
+		return nil, trace.Wrap(err, "describing Aurora clusters in %v", f.cfg.Region)
+	}
+	for _, cluster := range clustersOut.DBClusters {
+		if !f.matchesTags(rdsTagsToLabels(cluster.TagList)) {
+			continue
+		}
+		database, err := services.NewDatabaseFromRDSCluster(cluster, f.cfg.LabelsFromTags, f.cfg.AssumeRoleARN)
+		if err != nil {
+			return nil, trace.Wrap(err, "converting Aurora cluster %q", stringVal(cluster.DBClusterIdentifier))
+		}
+		databases = append(databases, database)
+	}
+
+	return databases, nil
+}
+
+// matchesTags returns true if tags satisfy the fetcher's selector.
+func (f *rdsFetcher) matchesTags(tags map[string]string) bool {
+	match, _, err := services.MatchLabels(f.cfg.Labels, tags)
+	if err != nil {
+		return false
+	}
+	return match
+}
+
+// String returns the fetcher's string representation.
+func (f *rdsFetcher) String() string {
+	return fmt.Sprintf("rdsFetcher(Region=%v, Labels=%v)", f.cfg.Region, f.cfg.Labels)
+}
+
+// rdsTagsToLabels converts RDS's pointer-valued resource tags to the plain
+// map[string]string that services.MatchLabels expects.
+func rdsTagsToLabels(tags []*rds.Tag) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		labels[stringVal(tag.Key)] = stringVal(tag.Value)
+	}
+	return labels
+}
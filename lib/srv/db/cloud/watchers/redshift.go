@@ -0,0 +1,122 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/redshift"
+	"github.com/aws/aws-sdk-go/service/redshift/redshiftiface"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/services"
+
+	"github.com/gravitational/trace"
+)
+
+// redshiftFetcherConfig is the Redshift fetcher configuration.
+type redshiftFetcherConfig struct {
+	// Labels are Redshift resource tags to match.
+	Labels types.Labels
+	// LabelsFromTags projects selected Redshift resource tags into Teleport
+	// labels, in addition to the match criteria in Labels.
+	LabelsFromTags map[string]string
+	// Redshift is the Redshift API client, scoped to Region (and, for
+	// cross-account discovery, already assuming AssumeRoleARN).
+	Redshift redshiftiface.RedshiftAPI
+	// Region is the AWS region to query databases in.
+	Region string
+	// AssumeRoleARN is the IAM role (in another account) Redshift was
+	// obtained by assuming, for cross-account discovery. Carried through to
+	// the databases this fetcher returns so the IAM configurator knows
+	// which account/role to grant access in.
+	AssumeRoleARN string
+}
+
+// CheckAndSetDefaults validates the config and sets defaults.
+func (c *redshiftFetcherConfig) CheckAndSetDefaults() error {
+	if len(c.Labels) == 0 {
+		return trace.BadParameter("missing parameter Labels")
+	}
+	if c.Redshift == nil {
+		return trace.BadParameter("missing parameter Redshift")
+	}
+	if c.Region == "" {
+		return trace.BadParameter("missing parameter Region")
+	}
+	return nil
+}
+
+// redshiftFetcher fetches Redshift clusters matching the selector, in a
+// single region.
+type redshiftFetcher struct {
+	cfg redshiftFetcherConfig
+}
+
+func newRedshiftFetcher(config redshiftFetcherConfig) (Fetcher, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &redshiftFetcher{cfg: config}, nil
+}
+
+// Get returns Redshift clusters matching the fetcher's selector.
+func (f *redshiftFetcher) Get(ctx context.Context) (types.Databases, error) {
+	var databases types.Databases
+
+	clustersOut, err := f.cfg.Redshift.DescribeClustersWithContext(ctx, &redshift.DescribeClustersInput{})
+	if err != nil {
+		return nil, trace.Wrap(err, "describing Redshift clusters in %v", f.cfg.Region)
+	}
+	for _, cluster := range clustersOut.Clusters {
+		if !f.matchesTags(redshiftTagsToLabels(cluster.Tags)) {
+			continue
+		}
+		database, err := services.NewDatabaseFromRedshiftCluster(cluster, f.cfg.LabelsFromTags, f.cfg.AssumeRoleARN)
+		if err != nil {
+			return nil, trace.Wrap(err, "converting Redshift cluster %q", stringVal(cluster.ClusterIdentifier))
+		}
+		databases = append(databases, database)
+	}
+
+	return databases, nil
+}
+
+// matchesTags returns true if tags satisfy the fetcher's selector.
+func (f *redshiftFetcher) matchesTags(tags map[string]string) bool {
+	match, _, err := services.MatchLabels(f.cfg.Labels, tags)
+	if err != nil {
+		return false
+	}
+	return match
+}
+
+// String returns the fetcher's string representation.
+func (f *redshiftFetcher) String() string {
+	return fmt.Sprintf("redshiftFetcher(Region=%v, Labels=%v)", f.cfg.Region, f.cfg.Labels)
+}
+
+// redshiftTagsToLabels converts Redshift's pointer-valued resource tags to
+// the plain map[string]string that services.MatchLabels expects.
+func redshiftTagsToLabels(tags []*redshift.Tag) map[string]string {
+	labels := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		labels[stringVal(tag.Key)] = stringVal(tag.Value)
+	}
+	return labels
+}
@@ -18,8 +18,15 @@ package watchers
 
 import (
 	"context"
+	"errors"
+	"math/rand"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/aws/aws-sdk-go/service/redshift/redshiftiface"
+
 	"github.com/gravitational/teleport/api/types"
 	"github.com/gravitational/teleport/lib/services"
 	"github.com/gravitational/teleport/lib/srv/db/common"
@@ -28,6 +35,34 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// coalesceWindow is how long the watcher buffers incremental notifications
+// before flushing them as a single update, so a burst of tag changes on one
+// instance collapses into a single reconciliation pass.
+const coalesceWindow = 2 * time.Second
+
+// defaultMaxConcurrency bounds how many fetcher shards run in parallel when
+// unset in WatcherConfig.
+const defaultMaxConcurrency = 10
+
+// maxFetchAttempts is the number of times a shard retries on AWS throttling
+// before giving up and reporting the error.
+const maxFetchAttempts = 5
+
+// maxConsecutiveFetchFailures is how many fetchAndSend rounds in a row a
+// shard may fail before its last known-good databases are dropped from the
+// cache. Below this threshold a failure is treated as transient (worth
+// preserving prior results for); at or beyond it, the shard is assumed to be
+// durably broken (revoked credentials, deleted selector target, etc.) and
+// its stale databases should stop being advertised.
+const maxConsecutiveFetchFailures = 3
+
+// fetchBackoffBase and fetchBackoffMax bound the exponential backoff a shard
+// waits between retries on AWS throttling.
+const (
+	fetchBackoffBase = 200 * time.Millisecond
+	fetchBackoffMax  = 10 * time.Second
+)
+
 // WatcherConfig is the cloud watcher configuration.
 type WatcherConfig struct {
 	// Selectors is a list of selectors to match databases against.
@@ -36,6 +71,14 @@ type WatcherConfig struct {
 	Clients common.CloudClients
 	// Interval is the interval between fetches.
 	Interval time.Duration
+	// Notifications configures an optional event-driven notification
+	// source that supplements (and eventually replaces the need to wait
+	// for) the periodic poll.
+	Notifications NotificationsConfig
+	// MaxConcurrency is the maximum number of fetcher shards (one per
+	// cloud/region/service combination makeFetchers produces) allowed to
+	// fetch concurrently. Defaults to defaultMaxConcurrency.
+	MaxConcurrency int
 }
 
 // CheckAndSetDefaults validates the config.
@@ -49,6 +92,9 @@ func (c *WatcherConfig) CheckAndSetDefaults() error {
 	if c.Interval == 0 {
 		c.Interval = 5 * time.Minute
 	}
+	if c.MaxConcurrency == 0 {
+		c.MaxConcurrency = defaultMaxConcurrency
+	}
 	return nil
 }
 
@@ -64,6 +110,37 @@ type Watcher struct {
 	fetchers []Fetcher
 	// databasesC is a channel where fetched databases are sent.
 	databasesC chan (types.Databases)
+	// errorsC is a channel where per-shard fetch errors are sent, so a
+	// single throttled or misconfigured shard is visible without blocking
+	// reconciliation of the databases every other shard found.
+	errorsC chan error
+
+	// mu guards cache and lastGood.
+	mu sync.Mutex
+	// cache is the last known state of every database the watcher has
+	// fetched, keyed by database name. It is updated wholesale by polling
+	// fetches and incrementally by notifications, and is always what gets
+	// sent on databasesC.
+	cache map[string]types.Database
+	// lastGood holds each fetcher's most recent successful result, keyed by
+	// its index into fetchers. When a shard's fetch fails, its entry is left
+	// untouched so that shard's databases survive in cache instead of being
+	// wiped by a transient throttle. A shard's entry is removed from
+	// lastGood (by fetchAndSend) once it has failed
+	// maxConsecutiveFetchFailures rounds in a row, and individual databases
+	// are scrubbed out of it (by applyEvent) as soon as a delete
+	// notification confirms they're actually gone.
+	lastGood map[int]types.Databases
+	// staleRounds counts, per fetcher index, how many fetchAndSend rounds in
+	// a row that shard has failed. Reset to 0 on success.
+	staleRounds map[int]int
+	// refetchPending is set by applyEvent when a notification describes a
+	// database without an up-to-date resource (creation/tag-change events,
+	// which only carry the source identifier). It's consumed by
+	// watchNotifications, which runs a full fetchAndSend instead of
+	// flushing the cache as-is, so the authoritative database is fetched
+	// before anything is sent downstream.
+	refetchPending bool
 }
 
 // Fetcher fetches cloud databases.
@@ -85,25 +162,41 @@ func NewWatcher(ctx context.Context, config WatcherConfig) (*Watcher, error) {
 		return nil, trace.NotFound("no cloud selectors")
 	}
 	return &Watcher{
-		cfg:        config,
-		log:        logrus.WithField(trace.Component, "watcher:cloud"),
-		ctx:        ctx,
-		fetchers:   fetchers,
-		databasesC: make(chan types.Databases),
+		cfg:         config,
+		log:         logrus.WithField(trace.Component, "watcher:cloud"),
+		ctx:         ctx,
+		fetchers:    fetchers,
+		databasesC:  make(chan types.Databases),
+		errorsC:     make(chan error, len(fetchers)),
+		cache:       make(map[string]types.Database),
+		lastGood:    make(map[int]types.Databases, len(fetchers)),
+		staleRounds: make(map[int]int, len(fetchers)),
 	}, nil
 }
 
 // Start starts fetching cloud databases and sending them to the channel.
 //
-// TODO(r0mant): In future, instead of (or in addition to) polling, we can
-// use a combination of EventBridge (former CloudWatch Events) and SQS/SNS to
-// subscribe to events such as created/removed instances and tag changes, but
-// this will require Teleport to have more AWS permissions.
+// When Notifications are configured, the watcher subscribes to incremental
+// upsert/delete events (fed by an EventBridge rule into SQS) and applies
+// them to its cache as they arrive, instead of waiting for the next poll
+// tick. The polling loop keeps running underneath as a correctness
+// safety-net and as the fallback path used when the subscription cannot be
+// established.
 func (w *Watcher) Start() {
-	ticker := time.NewTicker(w.cfg.Interval)
-	defer ticker.Stop()
 	w.log.Debugf("Starting cloud databases watcher.")
 	w.fetchAndSend()
+
+	if w.cfg.Notifications.IsConfigured() {
+		events, err := newSQSSubscriber(w.ctx, w.cfg.Notifications, w.cfg.Clients)
+		if err != nil {
+			w.log.WithError(err).Warn("Failed to subscribe to cloud database notifications, falling back to polling only.")
+		} else {
+			go w.watchNotifications(events)
+		}
+	}
+
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
@@ -115,19 +208,234 @@ func (w *Watcher) Start() {
 	}
 }
 
-// fetchAndSend fetches databases from all fetchers and sends them to the channel.
+// watchNotifications consumes incremental database events from the
+// subscriber, applies them to the cache and flushes a coalesced update once
+// the coalesce window has elapsed with no further events for the batch.
+func (w *Watcher) watchNotifications(events <-chan DatabaseEvent) {
+	var flush *time.Timer
+	defer func() {
+		if flush != nil {
+			flush.Stop()
+		}
+	}()
+	var flushC <-chan time.Time
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				w.log.Warn("Cloud database notification subscription closed, falling back to polling only.")
+				return
+			}
+			w.applyEvent(event)
+			if flush == nil {
+				flush = time.NewTimer(coalesceWindow)
+			} else {
+				if !flush.Stop() {
+					<-flushC
+				}
+				flush.Reset(coalesceWindow)
+			}
+			flushC = flush.C
+		case <-flushC:
+			if w.consumeRefetchPending() {
+				w.fetchAndSend()
+			} else {
+				w.send(w.snapshot())
+			}
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// applyEvent updates the cache according to a single incremental
+// notification. An upsert event without a resolved Database (creation and
+// tag-change events only carry the source identifier - see
+// parseRDSNotification) doesn't touch the cache; it instead marks a refetch
+// as pending so the next flush fetches the authoritative state instead of
+// trusting a nil placeholder.
+func (w *Watcher) applyEvent(event DatabaseEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch event.Op {
+	case DatabaseEventUpsert:
+		if event.Database == nil {
+			w.refetchPending = true
+			return
+		}
+		w.cache[event.Name] = event.Database
+	case DatabaseEventDelete:
+		delete(w.cache, event.Name)
+		// Scrub the deleted database out of lastGood too, otherwise the
+		// next round in which this database's shard fails would resurrect
+		// it from stale data, undoing the delete.
+		for i, databases := range w.lastGood {
+			w.lastGood[i] = removeByName(databases, event.Name)
+		}
+	default:
+		w.log.Warnf("Unknown cloud database event op %q for %q.", event.Op, event.Name)
+	}
+}
+
+// removeByName returns databases with the entry named name removed, if
+// present.
+func removeByName(databases types.Databases, name string) types.Databases {
+	for i, database := range databases {
+		if database.GetName() == name {
+			return append(databases[:i:i], databases[i+1:]...)
+		}
+	}
+	return databases
+}
+
+// consumeRefetchPending reports whether a refetch is pending and clears the
+// flag.
+func (w *Watcher) consumeRefetchPending() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := w.refetchPending
+	w.refetchPending = false
+	return pending
+}
+
+// fetchAndSend fetches databases from all fetchers concurrently (bounded by
+// MaxConcurrency), merges the results that succeeded into the cache, and
+// sends the result to the channel. A shard that keeps failing after
+// retries doesn't block the others: its error is reported on Errors(), and
+// that shard's last known-good databases are kept in the cache rather than
+// dropped, since a transient AWS throttle isn't evidence the databases are
+// actually gone. A shard failing maxConsecutiveFetchFailures rounds in a
+// row is no longer treated as transient, and its stale databases are
+// dropped from the cache.
 func (w *Watcher) fetchAndSend() {
+	sem := make(chan struct{}, w.cfg.MaxConcurrency)
+	var wg sync.WaitGroup
+	var resultMu sync.Mutex
+	succeeded := make(map[int]types.Databases)
+
+	for i, fetcher := range w.fetchers {
+		i, fetcher := i, fetcher
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			databases, err := fetchWithBackoff(w.ctx, fetcher)
+			if err != nil {
+				w.log.WithError(err).Errorf("%s failed.", fetcher)
+				w.sendError(trace.Wrap(err, "%s", fetcher))
+				return
+			}
+			resultMu.Lock()
+			succeeded[i] = databases
+			resultMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	for i := range w.fetchers {
+		if databases, ok := succeeded[i]; ok {
+			w.lastGood[i] = databases
+			delete(w.staleRounds, i)
+			continue
+		}
+		w.staleRounds[i]++
+		if w.staleRounds[i] >= maxConsecutiveFetchFailures {
+			delete(w.lastGood, i)
+		}
+	}
 	var result types.Databases
-	for _, fetcher := range w.fetchers {
-		databases, err := fetcher.Get(w.ctx)
-		if err != nil {
-			w.log.WithError(err).Errorf("%s failed.", fetcher)
-		} else {
-			result = append(result, databases...)
+	for _, databases := range w.lastGood {
+		result = append(result, databases...)
+	}
+	w.cache = make(map[string]types.Database, len(result))
+	for _, database := range result {
+		w.cache[database.GetName()] = database
+	}
+	w.mu.Unlock()
+
+	w.send(result)
+}
+
+// fetchWithBackoff calls fetcher.Get, retrying with exponential backoff and
+// jitter when it fails with an AWS throttling error, up to maxFetchAttempts
+// attempts. Any other error is returned immediately.
+func fetchWithBackoff(ctx context.Context, fetcher Fetcher) (types.Databases, error) {
+	backoff := fetchBackoffBase
+	var lastErr error
+	for attempt := 1; attempt <= maxFetchAttempts; attempt++ {
+		databases, err := fetcher.Get(ctx)
+		if err == nil {
+			return databases, nil
 		}
+		lastErr = err
+		if !isAWSThrottling(err) || attempt == maxFetchAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-ctx.Done():
+			return nil, trace.Wrap(ctx.Err())
+		}
+		if backoff *= 2; backoff > fetchBackoffMax {
+			backoff = fetchBackoffMax
+		}
+	}
+	return nil, trace.Wrap(lastErr)
+}
+
+// isAWSThrottling reports whether err (possibly wrapped by trace.Wrap) is an
+// AWS throttling error worth backing off and retrying.
+func isAWSThrottling(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
 	}
+	switch aerr.Code() {
+	case "ThrottlingException", "RequestLimitExceeded":
+		return true
+	default:
+		return false
+	}
+}
+
+// jitter returns a random duration in [d/2, d), so shards backing off at the
+// same time don't retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2+1)))
+}
+
+// sendError reports a shard's fetch error on the Errors channel, dropping it
+// if nobody is listening rather than blocking the fetch loop.
+func (w *Watcher) sendError(err error) {
 	select {
-	case w.databasesC <- result:
+	case w.errorsC <- err:
+	default:
+	}
+}
+
+// snapshot returns the current cache contents as a Databases slice.
+func (w *Watcher) snapshot() types.Databases {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make(types.Databases, 0, len(w.cache))
+	for _, database := range w.cache {
+		result = append(result, database)
+	}
+	return result
+}
+
+// send pushes a batch of databases to the channel, respecting context
+// cancellation.
+func (w *Watcher) send(databases types.Databases) {
+	select {
+	case w.databasesC <- databases:
 	case <-w.ctx.Done():
 	}
 }
@@ -137,19 +445,47 @@ func (w *Watcher) DatabasesC() <-chan types.Databases {
 	return w.databasesC
 }
 
+// Errors returns a channel that receives per-shard fetch errors, eg. a
+// single region that's still throttling after all retries. It's best-effort:
+// errors are dropped rather than buffered if nothing reads from it.
+func (w *Watcher) Errors() <-chan error {
+	return w.errorsC
+}
+
+// getAWSRDSClient returns an RDS client for region, assuming assumeRoleARN
+// in its own AWS account first if set, for cross-account RDS discovery.
+func getAWSRDSClient(clients common.CloudClients, region, assumeRoleARN, externalID string) (rdsiface.RDSAPI, error) {
+	if assumeRoleARN != "" {
+		return clients.GetAWSRDSClientForAccount(region, assumeRoleARN, externalID)
+	}
+	return clients.GetAWSRDSClient(region)
+}
+
+// getAWSRedshiftClient returns a Redshift client for region, assuming
+// assumeRoleARN in its own AWS account first if set, for cross-account
+// Redshift discovery.
+func getAWSRedshiftClient(clients common.CloudClients, region, assumeRoleARN, externalID string) (redshiftiface.RedshiftAPI, error) {
+	if assumeRoleARN != "" {
+		return clients.GetAWSRedshiftClientForAccount(region, assumeRoleARN, externalID)
+	}
+	return clients.GetAWSRedshiftClient(region)
+}
+
 // makeFetchers returns cloud fetchers for the provided regions/selectors.
 func makeFetchers(clients common.CloudClients, selectors []services.Selector) (fetchers []Fetcher, err error) {
 	for _, selector := range selectors {
 		if len(selector.MatchRDS.Tags) > 0 {
 			for _, region := range selector.MatchRDS.Regions {
-				rds, err := clients.GetAWSRDSClient(region)
+				rds, err := getAWSRDSClient(clients, region, selector.MatchRDS.AssumeRoleARN, selector.MatchRDS.ExternalID)
 				if err != nil {
 					return nil, trace.Wrap(err)
 				}
 				fetcher, err := newRDSFetcher(rdsFetcherConfig{
-					Labels: selector.MatchRDS.Tags,
-					RDS:    rds,
-					Region: region,
+					Labels:         selector.MatchRDS.Tags,
+					LabelsFromTags: selector.MatchRDS.LabelsFromTags,
+					RDS:            rds,
+					Region:         region,
+					AssumeRoleARN:  selector.MatchRDS.AssumeRoleARN,
 				})
 				if err != nil {
 					return nil, trace.Wrap(err)
@@ -157,6 +493,53 @@ func makeFetchers(clients common.CloudClients, selectors []services.Selector) (f
 				fetchers = append(fetchers, fetcher)
 			}
 		}
+		if len(selector.MatchRedshift.Tags) > 0 {
+			for _, region := range selector.MatchRedshift.Regions {
+				redshift, err := getAWSRedshiftClient(clients, region, selector.MatchRedshift.AssumeRoleARN, selector.MatchRedshift.ExternalID)
+				if err != nil {
+					return nil, trace.Wrap(err)
+				}
+				fetcher, err := newRedshiftFetcher(redshiftFetcherConfig{
+					Labels:         selector.MatchRedshift.Tags,
+					LabelsFromTags: selector.MatchRedshift.LabelsFromTags,
+					Redshift:       redshift,
+					Region:         region,
+					AssumeRoleARN:  selector.MatchRedshift.AssumeRoleARN,
+				})
+				if err != nil {
+					return nil, trace.Wrap(err)
+				}
+				fetchers = append(fetchers, fetcher)
+			}
+		}
+		if len(selector.MatchAzureSQL.Tags) > 0 {
+			fetcher, err := newAzureSQLFetcher(azureSQLFetcherConfig{
+				Labels:        selector.MatchAzureSQL.Tags,
+				Subscriptions: selector.MatchAzureSQL.Subscriptions,
+				Regions:       selector.MatchAzureSQL.Regions,
+				NewClient:     clients.GetAzureSQLClient,
+			})
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			fetchers = append(fetchers, fetcher)
+		}
+		if len(selector.MatchGCPSQL.Tags) > 0 {
+			gcpSQL, err := clients.GetGCPSQLAdminClient()
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			fetcher, err := newGCPSQLFetcher(gcpSQLFetcherConfig{
+				Labels:     selector.MatchGCPSQL.Tags,
+				ProjectIDs: selector.MatchGCPSQL.ProjectIDs,
+				Regions:    selector.MatchGCPSQL.Regions,
+				Client:     gcpSQL,
+			})
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			fetchers = append(fetchers, fetcher)
+		}
 	}
 	return fetchers, nil
 }
@@ -0,0 +1,220 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/defaults"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFetcher returns queued results in order, one per call to Get.
+// databases, if set, is returned on a nil error instead of an empty list.
+type fakeFetcher struct {
+	results   []error
+	databases types.Databases
+	calls     int
+}
+
+func (f *fakeFetcher) Get(context.Context) (types.Databases, error) {
+	err := f.results[f.calls]
+	f.calls++
+	if err != nil {
+		return nil, err
+	}
+	if f.databases != nil {
+		return f.databases, nil
+	}
+	return types.Databases{}, nil
+}
+
+func (f *fakeFetcher) String() string {
+	return "fakeFetcher"
+}
+
+func mustMakeDatabase(t *testing.T, name string) types.Database {
+	t.Helper()
+	database, err := types.NewDatabaseV3(types.Metadata{
+		Name: name,
+	}, types.DatabaseSpecV3{
+		Protocol: defaults.ProtocolPostgres,
+		URI:      "localhost",
+		AWS:      types.AWS{RDS: types.RDS{InstanceID: name}},
+	})
+	require.NoError(t, err)
+	return database
+}
+
+func TestFetchWithBackoffRetriesThrottling(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "rate exceeded", nil)
+	fetcher := &fakeFetcher{results: []error{throttled, throttled, nil}}
+
+	_, err := fetchWithBackoff(context.Background(), fetcher)
+	require.NoError(t, err)
+	require.Equal(t, 3, fetcher.calls)
+}
+
+func TestFetchWithBackoffGivesUpOnNonThrottlingError(t *testing.T) {
+	fetcher := &fakeFetcher{results: []error{awserr.New("AccessDenied", "nope", nil)}}
+
+	_, err := fetchWithBackoff(context.Background(), fetcher)
+	require.Error(t, err)
+	require.Equal(t, 1, fetcher.calls)
+}
+
+func TestIsAWSThrottling(t *testing.T) {
+	require.True(t, isAWSThrottling(awserr.New("ThrottlingException", "", nil)))
+	require.True(t, isAWSThrottling(awserr.New("RequestLimitExceeded", "", nil)))
+	require.False(t, isAWSThrottling(awserr.New("AccessDenied", "", nil)))
+	require.False(t, isAWSThrottling(nil))
+}
+
+// TestFetchAndSendPreservesCacheOnPartialFailure verifies that a shard which
+// fails (e.g. a region still throttling after all retries) doesn't wipe the
+// databases it found on a previous, successful round: only shards that
+// actually fetched this round should see their cache entries replaced.
+func TestFetchAndSendPreservesCacheOnPartialFailure(t *testing.T) {
+	good := mustMakeDatabase(t, "good-db")
+	goodFetcher := &fakeFetcher{
+		results:   []error{nil, nil},
+		databases: types.Databases{good},
+	}
+	throttled := awserr.New("ThrottlingException", "rate exceeded", nil)
+	var throttlingErrs []error
+	for i := 0; i < maxFetchAttempts; i++ {
+		throttlingErrs = append(throttlingErrs, throttled)
+	}
+	flakyFetcher := &fakeFetcher{results: throttlingErrs}
+
+	w := &Watcher{
+		cfg:         WatcherConfig{MaxConcurrency: 2},
+		log:         logrus.WithField(trace.Component, "watcher:cloud"),
+		ctx:         context.Background(),
+		fetchers:    []Fetcher{goodFetcher, flakyFetcher},
+		databasesC:  make(chan types.Databases, 1),
+		errorsC:     make(chan error, 2),
+		cache:       make(map[string]types.Database),
+		lastGood:    make(map[int]types.Databases, 2),
+		staleRounds: make(map[int]int, 2),
+	}
+
+	// First round: flakyFetcher succeeds too, so both shards populate the
+	// cache.
+	flakyFetcher.results = []error{nil}
+	flakyFetcher.databases = types.Databases{mustMakeDatabase(t, "flaky-db")}
+	w.fetchAndSend()
+	first := <-w.databasesC
+	require.Len(t, first, 2)
+
+	// Second round: flakyFetcher is throttled and exhausts its retries.
+	// goodFetcher's database must stay in the cache, and so must
+	// flakyFetcher's database from the previous round.
+	flakyFetcher.results = throttlingErrs
+	flakyFetcher.calls = 0
+	w.fetchAndSend()
+	second := <-w.databasesC
+	names := make(map[string]bool, len(second))
+	for _, database := range second {
+		names[database.GetName()] = true
+	}
+	require.True(t, names["good-db"])
+	require.True(t, names["flaky-db"])
+}
+
+// TestApplyEventNilUpsertDoesNotPoisonCache verifies that a name-only
+// upsert event (creation/tag-change notifications, which carry no resolved
+// Database) never writes a nil value into the cache - it only marks a
+// refetch as pending.
+func TestApplyEventNilUpsertDoesNotPoisonCache(t *testing.T) {
+	w := &Watcher{
+		log:      logrus.WithField(trace.Component, "watcher:cloud"),
+		cache:    make(map[string]types.Database),
+		lastGood: make(map[int]types.Databases),
+	}
+
+	w.applyEvent(DatabaseEvent{Op: DatabaseEventUpsert, Name: "some-db", Database: nil})
+
+	require.Empty(t, w.cache)
+	require.True(t, w.consumeRefetchPending())
+	require.False(t, w.consumeRefetchPending())
+
+	w.applyEvent(DatabaseEvent{Op: DatabaseEventUpsert, Name: "some-db", Database: mustMakeDatabase(t, "some-db")})
+	require.Contains(t, w.cache, "some-db")
+	require.False(t, w.consumeRefetchPending())
+}
+
+// TestApplyEventDeleteScrubsLastGood verifies that a delete notification
+// removes the database from lastGood as well as from cache, so a later round
+// in which that database's shard fails doesn't resurrect it from stale data.
+func TestApplyEventDeleteScrubsLastGood(t *testing.T) {
+	gone := mustMakeDatabase(t, "gone-db")
+	w := &Watcher{
+		log:   logrus.WithField(trace.Component, "watcher:cloud"),
+		cache: map[string]types.Database{"gone-db": gone},
+		lastGood: map[int]types.Databases{
+			0: {gone, mustMakeDatabase(t, "other-db")},
+		},
+	}
+
+	w.applyEvent(DatabaseEvent{Op: DatabaseEventDelete, Name: "gone-db"})
+
+	require.NotContains(t, w.cache, "gone-db")
+	names := make(map[string]bool)
+	for _, database := range w.lastGood[0] {
+		names[database.GetName()] = true
+	}
+	require.False(t, names["gone-db"])
+	require.True(t, names["other-db"])
+}
+
+// TestFetchAndSendDropsLastGoodAfterConsecutiveFailures verifies that a
+// shard failing maxConsecutiveFetchFailures rounds in a row has its stale
+// databases dropped from the cache, instead of being advertised forever.
+func TestFetchAndSendDropsLastGoodAfterConsecutiveFailures(t *testing.T) {
+	// A non-throttling error isn't retried, so each round fails on the
+	// first attempt instead of sleeping through maxFetchAttempts backoffs.
+	denied := []error{awserr.New("AccessDenied", "nope", nil)}
+	brokenFetcher := &fakeFetcher{results: denied}
+
+	w := &Watcher{
+		cfg:         WatcherConfig{MaxConcurrency: 1},
+		log:         logrus.WithField(trace.Component, "watcher:cloud"),
+		ctx:         context.Background(),
+		fetchers:    []Fetcher{brokenFetcher},
+		databasesC:  make(chan types.Databases, 1),
+		errorsC:     make(chan error, 1),
+		cache:       make(map[string]types.Database),
+		lastGood:    map[int]types.Databases{0: {mustMakeDatabase(t, "stale-db")}},
+		staleRounds: make(map[int]int, 1),
+	}
+
+	for i := 0; i < maxConsecutiveFetchFailures; i++ {
+		brokenFetcher.results = denied
+		brokenFetcher.calls = 0
+		w.fetchAndSend()
+		<-w.databasesC
+	}
+
+	require.NotContains(t, w.lastGood, 0)
+}
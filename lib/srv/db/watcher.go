@@ -38,6 +38,12 @@ func (s *Server) startReconciler(ctx context.Context) error {
 		OnUpdate:     s.onUpdate,
 		OnDelete:     s.onDelete,
 		Log:          s.log,
+		// The reconciler only ever sees databases as reported by static
+		// config, dynamic resources and cloud fetchers, so it owns the
+		// connection details and discovered labels wholesale - but not the
+		// rest of Metadata (eg. a description set directly via tctl), which
+		// an update would otherwise silently wipe out.
+		ManagedFields: []string{"Spec", "Metadata.Labels"},
 	})
 	if err != nil {
 		return trace.Wrap(err)
@@ -124,6 +130,8 @@ func (s *Server) startCloudDatabasesWatcher(ctx context.Context) error {
 				case <-ctx.Done():
 					return
 				}
+			case err := <-watcher.Errors():
+				s.log.WithError(err).Warn("Cloud database fetch shard failed.")
 			case <-ctx.Done():
 				return
 			}
@@ -196,7 +204,7 @@ func (s *Server) matcher(resource types.ResourceWithLabels) bool {
 	if !ok {
 		return false
 	}
-	if database.IsRDS() || database.IsRedshift() {
+	if database.IsRDS() || database.IsRedshift() || database.IsAzure() || database.IsCloudSQL() {
 		return true // Cloud fetchers return only matching databases.
 	}
 	return services.MatchResourceLabels(s.cfg.Selectors, database)
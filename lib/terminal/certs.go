@@ -0,0 +1,226 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// certsDirName is the subdirectory of HomeDir the Terminal service mints
+	// and loads its mTLS material from.
+	certsDirName = "terminal_certs"
+
+	caCertFileName     = "ca.pem"
+	serverCertFileName = "server.pem"
+	serverKeyFileName  = "server-key.pem"
+	clientCertFileName = "client.pem"
+	clientKeyFileName  = "client-key.pem"
+
+	// caCertTTL and serverCertTTL are long-lived, since both are tied to the
+	// lifetime of HomeDir rather than a single daemon run.
+	caCertTTL     = 10 * 365 * 24 * time.Hour
+	serverCertTTL = 10 * 365 * 24 * time.Hour
+	// clientCertTTL is short: the daemon mints a fresh client certificate on
+	// every start, so it only needs to outlive one run of the client.
+	clientCertTTL = 24 * time.Hour
+)
+
+// certPaths locates the PEM files the Terminal service mints for mTLS,
+// rooted at HomeDir.
+type certPaths struct {
+	dir string
+}
+
+func newCertPaths(homeDir string) certPaths {
+	return certPaths{dir: filepath.Join(homeDir, certsDirName)}
+}
+
+func (p certPaths) ca() string         { return filepath.Join(p.dir, caCertFileName) }
+func (p certPaths) serverCert() string { return filepath.Join(p.dir, serverCertFileName) }
+func (p certPaths) serverKey() string  { return filepath.Join(p.dir, serverKeyFileName) }
+func (p certPaths) clientCert() string { return filepath.Join(p.dir, clientCertFileName) }
+func (p certPaths) clientKey() string  { return filepath.Join(p.dir, clientKeyFileName) }
+
+// haveAll reports whether every file certPaths names already exists, so
+// CheckAndSetDefaults can skip regenerating material across restarts.
+func (p certPaths) haveAll() bool {
+	for _, path := range []string{p.ca(), p.serverCert(), p.serverKey(), p.clientCert(), p.clientKey()} {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateCertMaterial mints a self-signed CA plus a server certificate and
+// a short-lived client certificate signed by it, writing all five PEM files
+// under paths.dir. The Electron client is expected to pick up the client
+// certificate/key to dial back over mTLS.
+func generateCertMaterial(paths certPaths) error {
+	if err := os.MkdirAll(paths.dir, 0700); err != nil {
+		return trace.Wrap(err)
+	}
+
+	caKey, caCert, caDER, err := generateSelfSignedCA()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := writePEM(paths.ca(), "CERTIFICATE", caDER); err != nil {
+		return trace.Wrap(err)
+	}
+
+	serverDER, serverKey, err := generateLeafCert(caCert, caKey, "localhost", serverCertTTL, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := writeLeaf(paths.serverCert(), paths.serverKey(), serverDER, serverKey); err != nil {
+		return trace.Wrap(err)
+	}
+
+	clientDER, clientKey, err := generateLeafCert(caCert, caKey, "teleterm-client", clientCertTTL, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := writeLeaf(paths.clientCert(), paths.clientKey(), clientDER, clientKey); err != nil {
+		return trace.Wrap(err)
+	}
+
+	return nil
+}
+
+// generateSelfSignedCA mints a self-signed ECDSA P-256 CA certificate used
+// to sign the server and client leaves.
+func generateSelfSignedCA() (*ecdsa.PrivateKey, *x509.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, trace.Wrap(err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, nil, trace.Wrap(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "teleterm-local-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caCertTTL),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, trace.Wrap(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, trace.Wrap(err)
+	}
+	return key, cert, der, nil
+}
+
+// generateLeafCert mints an ECDSA P-256 certificate signed by ca/caKey for
+// commonName, valid for ttl and restricted to extKeyUsage.
+func generateLeafCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string, ttl time.Duration, extKeyUsage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	return der, key, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serial, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return serial, nil
+}
+
+func writePEM(path, blockType string, der []byte) error {
+	return trace.Wrap(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+func writeLeaf(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	if err := writePEM(certPath, "CERTIFICATE", der); err != nil {
+		return trace.Wrap(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(writePEM(keyPath, "EC PRIVATE KEY", keyDER))
+}
+
+// loadServerTLSConfig builds the tls.Config the Terminal service presents on
+// TCP binds: it serves serverCertFile/serverKeyFile and requires (and
+// verifies) a client certificate issued by clientCAFile.
+func loadServerTLSConfig(clientCAFile, serverCertFile, serverKeyFile string) (*tls.Config, error) {
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	caPEM, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, trace.BadParameter("no certificates found in %v", clientCAFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
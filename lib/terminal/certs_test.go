@@ -0,0 +1,79 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateCertMaterialProducesLoadableServerConfig(t *testing.T) {
+	paths := newCertPaths(t.TempDir())
+	require.False(t, paths.haveAll())
+
+	require.NoError(t, generateCertMaterial(paths))
+	require.True(t, paths.haveAll())
+
+	tlsConfig, err := loadServerTLSConfig(paths.ca(), paths.serverCert(), paths.serverKey())
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+}
+
+func TestGenerateCertMaterialClientCertVerifiesAgainstCA(t *testing.T) {
+	paths := newCertPaths(t.TempDir())
+	require.NoError(t, generateCertMaterial(paths))
+
+	caPEM, err := os.ReadFile(paths.ca())
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+
+	clientCert, err := tls.LoadX509KeyPair(paths.clientCert(), paths.clientKey())
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.NoError(t, err)
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	require.NoError(t, err)
+}
+
+func TestCheckAndSetDefaultsSkipsTLSForUnixAddr(t *testing.T) {
+	cfg := Config{
+		Addr:    "unix:///tmp/teleterm.sock",
+		HomeDir: t.TempDir(),
+	}
+	require.NoError(t, cfg.CheckAndSetDefaults())
+	require.Empty(t, cfg.ClientCAFile)
+	require.Empty(t, cfg.ServerCertFile)
+	require.Empty(t, cfg.ServerKeyFile)
+}
+
+func TestCheckAndSetDefaultsGeneratesTLSForTCPAddr(t *testing.T) {
+	cfg := Config{
+		Addr:    "tcp://127.0.0.1:0",
+		HomeDir: t.TempDir(),
+	}
+	require.NoError(t, cfg.CheckAndSetDefaults())
+	require.FileExists(t, cfg.ClientCAFile)
+	require.FileExists(t, cfg.ServerCertFile)
+	require.FileExists(t, cfg.ServerKeyFile)
+}
@@ -18,6 +18,7 @@ import (
 	"os"
 
 	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
 )
 
 // ServerOpts contains configuration options for a Teleport Terminal service.
@@ -30,6 +31,21 @@ type Config struct {
 	ShutdownSignals []os.Signal `json:"-"`
 	// HomeDir is the directory to store cluster profiles
 	HomeDir string `json:"homeDir"`
+	// Log is the logger used by the server. Defaults to the standard logrus
+	// logger tagged with the "terminal" component.
+	Log logrus.FieldLogger `json:"-"`
+	// ClientCAFile is the CA certificate (PEM) client certificates must
+	// chain to on TCP binds. Ignored for unix-socket binds, which are
+	// authenticated via SO_PEERCRED instead. If left empty and Addr is a
+	// TCP address, CheckAndSetDefaults mints a CA, server certificate, and
+	// short-lived client certificate into HomeDir and fills in this and the
+	// Server*File fields below.
+	ClientCAFile string `json:"clientCAFile"`
+	// ServerCertFile is this server's own certificate (PEM), presented to
+	// clients during the mTLS handshake on TCP binds.
+	ServerCertFile string `json:"serverCertFile"`
+	// ServerKeyFile is the private key (PEM) matching ServerCertFile.
+	ServerKeyFile string `json:"serverKeyFile"`
 }
 
 // CheckAndSetDefaults checks and sets default config values.
@@ -42,5 +58,25 @@ func (c *Config) CheckAndSetDefaults() error {
 		return trace.BadParameter("missing home directory")
 	}
 
+	if c.Log == nil {
+		c.Log = logrus.WithField(trace.Component, "terminal")
+	}
+
+	if network, _ := splitAddr(c.Addr); network != "unix" {
+		if c.ClientCAFile == "" && c.ServerCertFile == "" && c.ServerKeyFile == "" {
+			paths := newCertPaths(c.HomeDir)
+			if !paths.haveAll() {
+				if err := generateCertMaterial(paths); err != nil {
+					return trace.Wrap(err, "generating terminal mTLS certificates")
+				}
+			}
+			c.ClientCAFile = paths.ca()
+			c.ServerCertFile = paths.serverCert()
+			c.ServerKeyFile = paths.serverKey()
+		} else if c.ClientCAFile == "" || c.ServerCertFile == "" || c.ServerKeyFile == "" {
+			return trace.BadParameter("ClientCAFile, ServerCertFile and ServerKeyFile must all be set, or all left empty to auto-generate")
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,87 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// withRecovery returns a UnaryServerInterceptor that turns a panic in the
+// handler into a codes.Internal error instead of crashing the process,
+// logging the panic and a stack trace for diagnosis.
+func withRecovery(log logrus.FieldLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithFields(logrus.Fields{
+					"method": info.FullMethod,
+					"panic":  r,
+				}).WithField("stack", string(debug.Stack())).Error("grpc handler panicked")
+				err = status.Errorf(codes.Internal, "internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// withStreamRecovery is the streaming counterpart of withRecovery.
+func withStreamRecovery(log logrus.FieldLogger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.WithFields(logrus.Fields{
+					"method": info.FullMethod,
+					"panic":  r,
+				}).WithField("stack", string(debug.Stack())).Error("grpc handler panicked")
+				err = status.Errorf(codes.Internal, "internal error")
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// withLogging returns a UnaryServerInterceptor that logs each RPC's method
+// and latency once the handler returns.
+func withLogging(log logrus.FieldLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		log.WithFields(logrus.Fields{
+			"method":  info.FullMethod,
+			"latency": time.Since(start),
+		}).Debug("Handled gRPC request.")
+		return resp, err
+	}
+}
+
+// withStreamLogging is the streaming counterpart of withLogging.
+func withStreamLogging(log logrus.FieldLogger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		log.WithFields(logrus.Fields{
+			"method":  info.FullMethod,
+			"latency": time.Since(start),
+		}).Debug("Handled gRPC stream.")
+		return err
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithRecoveryConvertsPanicToInternalError(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	interceptor := withRecovery(log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestWithRecoveryPassesThroughNormalResults(t *testing.T) {
+	log, _ := test.NewNullLogger()
+	interceptor := withRecovery(log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	resp, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "ok", resp)
+}
+
+func TestWithLoggingPassesThroughResultAndError(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	interceptor := withLogging(log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handlerErr := errors.New("handler failed")
+
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, handlerErr
+	})
+
+	require.ErrorIs(t, err, handlerErr)
+	require.Len(t, hook.Entries, 1)
+	require.Equal(t, "/test.Service/Method", hook.LastEntry().Data["method"])
+}
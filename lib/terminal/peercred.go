@@ -0,0 +1,62 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"net"
+	"os"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// peerCredListener wraps a unix socket listener, rejecting connections from
+// a peer UID other than the process owner's before they ever reach gRPC.
+// This is the unix-socket analogue of the mTLS requirement used for TCP
+// binds: the local Electron client and the daemon always run as the same
+// user, so SO_PEERCRED is a strictly simpler and equally strong check.
+type peerCredListener struct {
+	*net.UnixListener
+	uid int
+}
+
+// newPeerCredListener wraps l with the SO_PEERCRED check, refusing to do so
+// on platforms where verifyPeerUID isn't backed by a real implementation
+// (see peercred_other.go): serving an unauthenticated unix socket there
+// would silently accept connections from any local user.
+func newPeerCredListener(l *net.UnixListener, log logrus.FieldLogger) (*peerCredListener, error) {
+	if !peerCredSupported {
+		log.Error("SO_PEERCRED is not implemented on this platform; refusing to serve the Teleport Terminal service over a unix socket rather than accept connections from any local peer. Use a TCP address with mTLS instead.")
+		return nil, trace.BadParameter("unix-socket peer verification is not supported on this platform")
+	}
+	return &peerCredListener{UnixListener: l, uid: os.Getuid()}, nil
+}
+
+// Accept blocks until it can return a connection from a peer running as the
+// expected UID, silently dropping and skipping any connection that fails the
+// check rather than surfacing it as a listener error.
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyPeerUID(conn, l.uid); err != nil {
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package terminal
+
+import (
+	"net"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/sys/unix"
+)
+
+// peerCredSupported is true on Linux, where verifyPeerUID below is backed by
+// a real SO_PEERCRED check.
+const peerCredSupported = true
+
+// verifyPeerUID checks, via SO_PEERCRED, that the peer on the other end of
+// conn is running as wantUID, rejecting connections from any other local
+// user before they ever reach a gRPC handler.
+func verifyPeerUID(conn *net.UnixConn, wantUID int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var ucred *unix.Ucred
+	var sysErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, sysErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return trace.Wrap(err)
+	}
+	if sysErr != nil {
+		return trace.Wrap(sysErr)
+	}
+
+	if int(ucred.Uid) != wantUID {
+		return trace.AccessDenied("rejecting terminal connection from uid %d, expected %d", ucred.Uid, wantUID)
+	}
+	return nil
+}
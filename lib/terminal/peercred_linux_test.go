@@ -0,0 +1,69 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package terminal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPeerUIDAcceptsOwnProcess(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sockPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	clientDone := make(chan error, 1)
+	go func() {
+		conn, dialErr := net.Dial("unix", sockPath)
+		if dialErr == nil {
+			conn.Close()
+		}
+		clientDone <- dialErr
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, verifyPeerUID(conn.(*net.UnixConn), os.Getuid()))
+	require.NoError(t, <-clientDone)
+}
+
+func TestVerifyPeerUIDRejectsUnexpectedUID(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "test.sock")
+	listener, err := net.Listen("unix", sockPath)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, dialErr := net.Dial("unix", sockPath)
+		if dialErr == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Error(t, verifyPeerUID(conn.(*net.UnixConn), os.Getuid()+1))
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package terminal
+
+import (
+	"net"
+
+	"github.com/gravitational/trace"
+)
+
+// peerCredSupported is false on platforms without a real SO_PEERCRED
+// implementation (see peercred_linux.go for the one real implementation).
+// This includes macOS, the Electron client's primary non-Linux platform, so
+// newPeerCredListener refuses to serve over a unix socket here rather than
+// silently accept connections from any local peer.
+const peerCredSupported = false
+
+// verifyPeerUID always fails closed on platforms without SO_PEERCRED
+// support: there's no way to check the peer's identity, and accepting every
+// peer would defeat the purpose of the check. newPeerCredListener already
+// refuses to construct a listener when peerCredSupported is false, so this
+// should never be reached in practice.
+func verifyPeerUID(conn *net.UnixConn, wantUID int) error {
+	return trace.AccessDenied("SO_PEERCRED peer verification is not supported on this platform")
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Gravitational, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terminal
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Server is the Teleport Terminal (Teleterm) gRPC service. It's normally
+// reached over a local unix socket by the Electron-based Connect client, in
+// which case the peer is authenticated via SO_PEERCRED; if Addr is instead a
+// TCP address, the server requires mTLS using certificates generated (or
+// pinned) via Config. Either way, the interceptor chain adds panic recovery
+// and per-RPC latency logging.
+type Server struct {
+	cfg        Config
+	grpcServer *grpc.Server
+	listener   net.Listener
+}
+
+// New creates a Teleport Terminal service from cfg, but does not start
+// listening - call Serve for that.
+func New(cfg Config) (*Server, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			withRecovery(cfg.Log),
+			withLogging(cfg.Log),
+		),
+		grpc.ChainStreamInterceptor(
+			withStreamRecovery(cfg.Log),
+			withStreamLogging(cfg.Log),
+		),
+	}
+
+	if network, _ := splitAddr(cfg.Addr); network != "unix" {
+		tlsConfig, err := loadServerTLSConfig(cfg.ClientCAFile, cfg.ServerCertFile, cfg.ServerKeyFile)
+		if err != nil {
+			return nil, trace.Wrap(err, "loading terminal mTLS certificates")
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	return &Server{
+		cfg:        cfg,
+		grpcServer: grpc.NewServer(opts...),
+	}, nil
+}
+
+// GRPCServer returns the underlying grpc.Server so that service
+// implementations can be registered before Serve is called.
+func (s *Server) GRPCServer() *grpc.Server {
+	return s.grpcServer
+}
+
+// Serve starts listening on the configured address and blocks serving
+// incoming gRPC connections until the listener is closed or Stop is called.
+func (s *Server) Serve() error {
+	listener, err := parseAndListen(s.cfg.Addr)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		listener, err = newPeerCredListener(unixListener, s.cfg.Log)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	s.listener = listener
+
+	s.cfg.Log.WithField("addr", s.cfg.Addr).Info("Starting Teleport Terminal service.")
+	if err := s.grpcServer.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// Stop gracefully stops the server, waiting for in-flight requests to
+// complete.
+func (s *Server) Stop() {
+	s.grpcServer.GracefulStop()
+}
+
+// splitAddr splits addr, which may be given either as "scheme://host:port"
+// (eg. "unix:///path/to/socket" or "tcp://host:port") or as a bare
+// "host:port", in which case "tcp" is assumed, into its network and address
+// parts.
+func splitAddr(addr string) (network, address string) {
+	network, address = "tcp", addr
+	if parts := strings.SplitN(addr, "://", 2); len(parts) == 2 {
+		network, address = parts[0], parts[1]
+	}
+	return network, address
+}
+
+// parseAndListen listens on addr, as parsed by splitAddr.
+func parseAndListen(addr string) (net.Listener, error) {
+	network, address := splitAddr(addr)
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, trace.Wrap(err, "listening on %v", addr)
+	}
+	return listener, nil
+}